@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// BenchmarkResult is the recall and the latency of the KNN search for some index settings.
+type BenchmarkResult struct {
+	Settings IndexSettings
+	Recall   float64
+	Latency  time.Duration
+}
+
+// storedVector is a chunk embedding read back from Redis.
+type storedVector struct {
+	Key    string
+	Vector []float32
+}
+
+// LoadStoredVectors reads all the embeddings stored under the key prefix.
+func LoadStoredVectors(ctx context.Context, rdb *redis.Client, keyPrefix string) ([]storedVector, error) {
+	vectors := []storedVector{}
+	iter := rdb.Scan(ctx, 0, keyPrefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		data, err := rdb.HGet(ctx, iter.Val(), "embedding").Result()
+		if err != nil {
+			continue // not a chunk
+		}
+		vectors = append(vectors, storedVector{Key: iter.Val(), Vector: bytesToFloats([]byte(data))})
+	}
+	sort.Slice(vectors, func(i, j int) bool { return vectors[i].Key < vectors[j].Key })
+	return vectors, iter.Err()
+}
+
+// BruteForceKNN returns the keys of the k nearest vectors, computed by comparing the query with every vector.
+func BruteForceKNN(metric string, query []float32, vectors []storedVector, k int) []string {
+	type candidate struct {
+		key      string
+		distance float64
+	}
+	candidates := make([]candidate, len(vectors))
+	for idx, stored := range vectors {
+		candidates[idx] = candidate{key: stored.Key, distance: Distance(metric, query, stored.Vector)}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].distance < candidates[j].distance })
+
+	keys := []string{}
+	for idx := 0; idx < k && idx < len(candidates); idx++ {
+		keys = append(keys, candidates[idx].key)
+	}
+	return keys
+}
+
+// BenchmarkIndex creates a temporary index with the settings over the chunks already stored in Redis,
+// runs a KNN search for every query and compares the results with a brute-force search.
+//
+// Parameters:
+//   - ctx: The context.
+//   - rdb: The Redis client.
+//   - keyPrefix: The key prefix of the stored chunks.
+//   - settings: The index settings to evaluate.
+//   - queries: The query vectors.
+//   - vectors: All the stored vectors (for the brute-force search).
+//   - k: The number of neighbors.
+//
+// Returns:
+//   - BenchmarkResult: The average recall@k and the average search latency.
+//   - error: An error if the index can't be created or searched.
+func BenchmarkIndex(ctx context.Context, rdb *redis.Client, keyPrefix string, settings IndexSettings, queries [][]float32, vectors []storedVector, k int) (BenchmarkResult, error) {
+	result := BenchmarkResult{Settings: settings}
+	indexName := "benchmark_idx"
+
+	rdb.FTDropIndex(ctx, indexName) // the documents are kept
+	_, err := rdb.FTCreate(ctx,
+		indexName,
+		&redis.FTCreateOptions{
+			OnHash: true,
+			Prefix: []any{keyPrefix},
+		},
+		&redis.FieldSchema{
+			FieldName:  "embedding",
+			FieldType:  redis.SearchFieldTypeVector,
+			VectorArgs: settings.VectorArgs(),
+		},
+	).Result()
+	if err != nil {
+		return result, err
+	}
+	defer rdb.FTDropIndex(ctx, indexName)
+
+	// wait for Redis to index the existing documents
+	for {
+		info, err := rdb.FTInfo(ctx, indexName).Result()
+		if err != nil {
+			return result, err
+		}
+		if info.Indexing == 0 {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	totalRecall := 0.0
+	var totalLatency time.Duration
+	for _, query := range queries {
+		expected := BruteForceKNN(settings.DistanceMetric, query, vectors, k)
+
+		start := time.Now()
+		results, err := rdb.FTSearchWithArgs(ctx,
+			indexName,
+			fmt.Sprintf("*=>[KNN %d @embedding $vec AS vector_distance]", k),
+			&redis.FTSearchOptions{
+				Return:         []redis.FTSearchReturn{{FieldName: "vector_distance"}},
+				DialectVersion: 2,
+				Params:         map[string]any{"vec": floatsToBytes(query)},
+			},
+		).Result()
+		if err != nil {
+			return result, err
+		}
+		totalLatency += time.Since(start)
+
+		found := 0
+		for _, doc := range results.Docs {
+			for _, key := range expected {
+				if doc.ID == key {
+					found++
+					break
+				}
+			}
+		}
+		if len(expected) > 0 {
+			totalRecall += float64(found) / float64(len(expected))
+		}
+	}
+
+	if len(queries) > 0 {
+		result.Recall = totalRecall / float64(len(queries))
+		result.Latency = totalLatency / time.Duration(len(queries))
+	}
+	return result, nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// EmbeddingCache stores the embeddings in Redis, keyed by embedding model and content hash,
+// so an unchanged chunk (or a repeated question) is never embedded twice.
+type EmbeddingCache struct {
+	Redis  *redis.Client
+	Prefix string
+
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+// embeddingCachePrefix is the key prefix of the embedding cache.
+const embeddingCachePrefix = "embcache:"
+
+// NewEmbeddingCache creates an embedding cache using the "embcache:" key prefix.
+func NewEmbeddingCache(rdb *redis.Client) *EmbeddingCache {
+	return &EmbeddingCache{Redis: rdb, Prefix: embeddingCachePrefix}
+}
+
+// NormalizeText normalizes a text before hashing it: the leading, trailing
+// and repeated white spaces don't change the key.
+func NormalizeText(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// Key returns the Redis key of the embedding of a text: <prefix><model>:<sha256 of the normalized text>.
+func (c *EmbeddingCache) Key(model, text string) string {
+	hash := sha256.Sum256([]byte(NormalizeText(text)))
+	return c.Prefix + model + ":" + hex.EncodeToString(hash[:])
+}
+
+// Get returns the cached embedding of a text, if any.
+func (c *EmbeddingCache) Get(ctx context.Context, model, text string) ([]float32, bool) {
+	data, err := c.Redis.Get(ctx, c.Key(model, text)).Bytes()
+	if err != nil {
+		c.misses.Add(1)
+		c.Redis.HIncrBy(ctx, c.Prefix+"stats", "misses", 1)
+		return nil, false
+	}
+	c.hits.Add(1)
+	c.Redis.HIncrBy(ctx, c.Prefix+"stats", "hits", 1)
+	return bytesToFloats(data), true
+}
+
+// Set stores the embedding of a text.
+func (c *EmbeddingCache) Set(ctx context.Context, model, text string, embedding []float32) error {
+	return c.Redis.Set(ctx, c.Key(model, text), floatsToBytes(embedding), 0).Err()
+}
+
+// Stats returns the hits and the misses of the current run.
+func (c *EmbeddingCache) Stats() (hits, misses int64) {
+	return c.hits.Load(), c.misses.Load()
+}
+
+// TotalStats returns the hits and the misses of all the runs, and the number of cached embeddings.
+func (c *EmbeddingCache) TotalStats(ctx context.Context) (hits, misses, entries int64, err error) {
+	stats, err := c.Redis.HGetAll(ctx, c.Prefix+"stats").Result()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	hits, _ = strconv.ParseInt(stats["hits"], 10, 64)
+	misses, _ = strconv.ParseInt(stats["misses"], 10, 64)
+
+	iter := c.Redis.Scan(ctx, 0, c.Prefix+"*", 1000).Iterator()
+	for iter.Next(ctx) {
+		if iter.Val() != c.Prefix+"stats" {
+			entries++
+		}
+	}
+	return hits, misses, entries, iter.Err()
+}
+
+// Purge deletes the cached embeddings of a model (of all the models if model is empty),
+// and resets the stats when everything is purged.
+func (c *EmbeddingCache) Purge(ctx context.Context, model string) (int, error) {
+	pattern := c.Prefix + "*"
+	if model != "" {
+		pattern = c.Prefix + model + ":*"
+	}
+	deleted := 0
+	iter := c.Redis.Scan(ctx, 0, pattern, 1000).Iterator()
+	for iter.Next(ctx) {
+		if err := c.Redis.Del(ctx, iter.Val()).Err(); err != nil {
+			return deleted, err
+		}
+		if iter.Val() != c.Prefix+"stats" {
+			deleted++
+		}
+	}
+	return deleted, iter.Err()
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RetrievedChunk is a chunk returned by the vector search,
+// with enough metadata to rebuild contiguous text from overlapping chunks.
+type RetrievedChunk struct {
+	ID       string   `json:"id"`
+	Source   string   `json:"source"`
+	Section  string   `json:"section,omitempty"`
+	Start    int      `json:"start"`
+	Content  string   `json:"content"`
+	Distance float64  `json:"distance"`
+	Parent   string   `json:"parent,omitempty"`  // the key of the parent chunk (parent-document retrieval)
+	Sources  []string `json:"sources,omitempty"` // the other sources of the chunk (merged duplicates)
+}
+
+// End returns the offset (in the source document) right after the last byte of the chunk.
+func (c RetrievedChunk) End() int {
+	return c.Start + len(c.Content)
+}
+
+// ContextBudget describes how many tokens of the chat model context can be used by the knowledge base.
+type ContextBudget struct {
+	ContextLength  int // context length of the chat model
+	PromptTokens   int // tokens already used by the system instructions and the user question
+	ReservedTokens int // tokens kept for the answer
+}
+
+// Available returns the number of tokens left for the knowledge base.
+func (b ContextBudget) Available() int {
+	available := b.ContextLength - b.PromptTokens - b.ReservedTokens
+	if available < 0 {
+		return 0
+	}
+	return available
+}
+
+// EstimateTokens gives a rough estimation of the number of tokens of a text.
+// We don't have access to the tokenizer of the model, so we use the usual
+// "1 token ~= 4 characters" approximation.
+func EstimateTokens(text string) int {
+	return (len(text) + 3) / 4
+}
+
+// DocumentsToChunks converts the documents of a Redis search result to retrieved chunks.
+func DocumentsToChunks(docs []redis.Document) []RetrievedChunk {
+	chunks := make([]RetrievedChunk, 0, len(docs))
+	for _, doc := range docs {
+		distance, _ := strconv.ParseFloat(doc.Fields["vector_distance"], 64)
+		start, err := strconv.Atoi(doc.Fields["start"])
+		if err != nil {
+			start = -1 // unknown position, the chunk will never be merged
+		}
+		chunk := RetrievedChunk{
+			ID:       doc.ID,
+			Source:   doc.Fields["source"],
+			Section:  doc.Fields["section"],
+			Start:    start,
+			Content:  doc.Fields["content"],
+			Distance: distance,
+			Parent:   doc.Fields["parent"],
+		}
+		if sources := doc.Fields["sources"]; sources != "" {
+			chunk.Sources = strings.Split(sources, ",")
+		}
+		chunks = append(chunks, chunk)
+	}
+	return chunks
+}
+
+// BuildKnowledgeBase packs the retrieved chunks into a knowledge base that fits the token budget.
+//
+// The chunks are:
+//   - ordered by score (the lowest distance first),
+//   - kept while they fit in the budget (the last one can be truncated),
+//   - merged back into contiguous text when they come from the same source and overlap,
+//   - separated by labeled delimiters.
+//
+// Parameters:
+//   - chunks: The chunks returned by the vector search.
+//   - budget: The token budget for the knowledge base.
+//
+// Returns:
+//   - string: The knowledge base to send to the model.
+//   - []RetrievedChunk: The blocks of text that were kept (after merging).
+func BuildKnowledgeBase(chunks []RetrievedChunk, budget ContextBudget) (string, []RetrievedChunk) {
+	sorted := make([]RetrievedChunk, len(chunks))
+	copy(sorted, chunks)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Distance < sorted[j].Distance
+	})
+
+	// keep the best chunks that fit in the budget
+	available := budget.Available()
+	selected := []RetrievedChunk{}
+	used := 0
+	for _, chunk := range sorted {
+		// count the delimiter too
+		cost := EstimateTokens(chunk.Content) + EstimateTokens(delimiter(len(selected)+1, chunk))
+		if used+cost <= available {
+			selected = append(selected, chunk)
+			used += cost
+			continue
+		}
+		// truncate the last chunk with the remaining budget
+		remaining := (available - used - EstimateTokens(delimiter(len(selected)+1, chunk))) * 4
+		if remaining > 0 && remaining < len(chunk.Content) {
+			if chunk.Content = truncateText(chunk.Content, remaining); chunk.Content != "" {
+				selected = append(selected, chunk)
+			}
+		}
+		break
+	}
+
+	blocks := MergeOverlappingChunks(selected)
+
+	var knowledgeBase strings.Builder
+	for idx, block := range blocks {
+		knowledgeBase.WriteString(delimiter(idx+1, block))
+		knowledgeBase.WriteString(strings.TrimSpace(block.Content))
+		knowledgeBase.WriteString("\n\n")
+	}
+	return knowledgeBase.String(), blocks
+}
+
+// truncateText returns the beginning of the text, at most size bytes long.
+// The text is cut at the last whitespace when there is one, else at a rune boundary
+// (the documents contain accents and emoji: a byte cut could split a UTF-8 character).
+func truncateText(text string, size int) string {
+	if size >= len(text) {
+		return text
+	}
+	for size > 0 && !utf8.RuneStart(text[size]) {
+		size--
+	}
+	if space := strings.LastIndexFunc(text[:size], unicode.IsSpace); space > 0 {
+		size = space
+	}
+	return text[:size]
+}
+
+// MergeOverlappingChunks merges the chunks that come from the same source
+// and overlap (or touch each other) back into contiguous text.
+// The merged block keeps the best distance of its chunks,
+// and the blocks are returned ordered by distance.
+func MergeOverlappingChunks(chunks []RetrievedChunk) []RetrievedChunk {
+	sorted := make([]RetrievedChunk, len(chunks))
+	copy(sorted, chunks)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Source != sorted[j].Source {
+			return sorted[i].Source < sorted[j].Source
+		}
+		if sorted[i].Section != sorted[j].Section {
+			return sorted[i].Section < sorted[j].Section
+		}
+		return sorted[i].Start < sorted[j].Start
+	})
+
+	blocks := []RetrievedChunk{}
+	for _, chunk := range sorted {
+		if len(blocks) > 0 {
+			last := &blocks[len(blocks)-1]
+			if mergeable(*last, chunk) {
+				if chunk.End() > last.End() {
+					last.Content += chunk.Content[last.End()-chunk.Start:]
+				}
+				last.ID += "+" + chunk.ID
+				last.Distance = min(last.Distance, chunk.Distance)
+				continue
+			}
+		}
+		blocks = append(blocks, chunk)
+	}
+
+	sort.SliceStable(blocks, func(i, j int) bool {
+		return blocks[i].Distance < blocks[j].Distance
+	})
+	return blocks
+}
+
+func mergeable(previous, next RetrievedChunk) bool {
+	return previous.Source != "" &&
+		previous.Source == next.Source &&
+		previous.Section == next.Section &&
+		previous.Start >= 0 && next.Start >= 0 &&
+		next.Start <= previous.End()
+}
+
+func delimiter(number int, chunk RetrievedChunk) string {
+	source := "unknown"
+	if chunk.Source != "" {
+		source = filepath.Base(chunk.Source)
+	}
+	if chunk.Section != "" {
+		source += " " + chunk.Section
+	}
+	for _, other := range chunk.Sources {
+		source += ", " + filepath.Base(other)
+	}
+	return fmt.Sprintf("--- [%d] source: %s (distance: %.4f) ---\n", number, source, chunk.Distance)
+}
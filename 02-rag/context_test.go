@@ -0,0 +1,161 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestMergeOverlappingChunks(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunks []RetrievedChunk
+		want   []RetrievedChunk
+	}{
+		{
+			name: "overlapping chunks of a source",
+			chunks: []RetrievedChunk{
+				{ID: "b", Source: "a.md", Start: 6, Content: "world!", Distance: 0.2},
+				{ID: "a", Source: "a.md", Start: 0, Content: "Hello wor", Distance: 0.3},
+			},
+			want: []RetrievedChunk{
+				{ID: "a+b", Source: "a.md", Start: 0, Content: "Hello world!", Distance: 0.2},
+			},
+		},
+		{
+			name: "touching chunks",
+			chunks: []RetrievedChunk{
+				{ID: "a", Source: "a.md", Start: 0, Content: "Hello ", Distance: 0.1},
+				{ID: "b", Source: "a.md", Start: 6, Content: "world", Distance: 0.2},
+			},
+			want: []RetrievedChunk{
+				{ID: "a+b", Source: "a.md", Start: 0, Content: "Hello world", Distance: 0.1},
+			},
+		},
+		{
+			name: "chunk inside the previous one",
+			chunks: []RetrievedChunk{
+				{ID: "a", Source: "a.md", Start: 0, Content: "Hello world", Distance: 0.4},
+				{ID: "b", Source: "a.md", Start: 2, Content: "llo", Distance: 0.1},
+			},
+			want: []RetrievedChunk{
+				{ID: "a+b", Source: "a.md", Start: 0, Content: "Hello world", Distance: 0.1},
+			},
+		},
+		{
+			name: "gap between the chunks, sorted by distance",
+			chunks: []RetrievedChunk{
+				{ID: "a", Source: "a.md", Start: 0, Content: "Hello", Distance: 0.3},
+				{ID: "b", Source: "a.md", Start: 10, Content: "world", Distance: 0.1},
+			},
+			want: []RetrievedChunk{
+				{ID: "b", Source: "a.md", Start: 10, Content: "world", Distance: 0.1},
+				{ID: "a", Source: "a.md", Start: 0, Content: "Hello", Distance: 0.3},
+			},
+		},
+		{
+			name: "different sections and sources, unknown start",
+			chunks: []RetrievedChunk{
+				{ID: "a", Source: "a.md", Section: "row-1", Start: 0, Content: "Hello", Distance: 0.1},
+				{ID: "b", Source: "a.md", Section: "row-2", Start: 0, Content: "Hello", Distance: 0.2},
+				{ID: "c", Source: "b.md", Start: 0, Content: "Hello", Distance: 0.3},
+				{ID: "d", Source: "b.md", Start: -1, Content: "Hello", Distance: 0.4},
+			},
+			want: []RetrievedChunk{
+				{ID: "a", Source: "a.md", Section: "row-1", Start: 0, Content: "Hello", Distance: 0.1},
+				{ID: "b", Source: "a.md", Section: "row-2", Start: 0, Content: "Hello", Distance: 0.2},
+				{ID: "c", Source: "b.md", Start: 0, Content: "Hello", Distance: 0.3},
+				{ID: "d", Source: "b.md", Start: -1, Content: "Hello", Distance: 0.4},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			blocks := MergeOverlappingChunks(test.chunks)
+			if len(blocks) != len(test.want) {
+				t.Fatalf("expected %d blocks, got %d: %+v", len(test.want), len(blocks), blocks)
+			}
+			for idx, block := range blocks {
+				want := test.want[idx]
+				if block.ID != want.ID || block.Content != want.Content || block.Start != want.Start || block.Distance != want.Distance {
+					t.Errorf("block #%d: expected %+v, got %+v", idx, want, block)
+				}
+			}
+		})
+	}
+}
+
+func TestBuildKnowledgeBase(t *testing.T) {
+	// budget leaving `tokens` tokens (4 bytes each) for the content of the first chunk after its delimiter
+	budgetFor := func(chunk RetrievedChunk, tokens int) ContextBudget {
+		return ContextBudget{ContextLength: EstimateTokens(delimiter(1, chunk)) + tokens}
+	}
+	large := ContextBudget{ContextLength: 10000}
+	accents := RetrievedChunk{ID: "a", Source: "fr.md", Content: "ananas été 🍍🍍🍍🍍🍍🍍", Distance: 0.1}
+	noSpace := RetrievedChunk{ID: "a", Source: "fr.md", Content: "aééééééééé", Distance: 0.1}
+
+	tests := []struct {
+		name   string
+		chunks []RetrievedChunk
+		budget ContextBudget
+		want   []string // the content of the blocks
+	}{
+		{
+			name: "everything fits, best distance first",
+			chunks: []RetrievedChunk{
+				{ID: "a", Source: "a.md", Content: "far", Distance: 0.9},
+				{ID: "b", Source: "b.md", Content: "close", Distance: 0.1},
+			},
+			budget: large,
+			want:   []string{"close", "far"},
+		},
+		{
+			name: "overlapping chunks are merged",
+			chunks: []RetrievedChunk{
+				{ID: "a", Source: "a.md", Start: 0, Content: "Hawaiian pizza was ", Distance: 0.2},
+				{ID: "b", Source: "a.md", Start: 15, Content: "was invented in 1962", Distance: 0.1},
+			},
+			budget: large,
+			want:   []string{"Hawaiian pizza was invented in 1962"},
+		},
+		{
+			name:   "truncated at the last whitespace, not inside an emoji",
+			chunks: []RetrievedChunk{accents},
+			budget: budgetFor(accents, 4), // 16 bytes: the cut falls inside the first emoji
+			want:   []string{"ananas été"},
+		},
+		{
+			name:   "truncated at a rune boundary without whitespace",
+			chunks: []RetrievedChunk{noSpace},
+			budget: budgetFor(noSpace, 1), // 4 bytes: the cut falls inside the second é
+			want:   []string{"aé"},
+		},
+		{
+			name:   "no budget",
+			chunks: []RetrievedChunk{accents},
+			budget: ContextBudget{ContextLength: 100, PromptTokens: 80, ReservedTokens: 30},
+			want:   []string{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			knowledgeBase, blocks := BuildKnowledgeBase(test.chunks, test.budget)
+			if !utf8.ValidString(knowledgeBase) {
+				t.Fatalf("invalid UTF-8 in the knowledge base: %q", knowledgeBase)
+			}
+			if len(blocks) != len(test.want) {
+				t.Fatalf("expected %d blocks, got %d: %+v", len(test.want), len(blocks), blocks)
+			}
+			for idx, block := range blocks {
+				if block.Content != test.want[idx] {
+					t.Errorf("block #%d: expected %q, got %q", idx, test.want[idx], block.Content)
+				}
+				if !strings.Contains(knowledgeBase, delimiter(idx+1, block)+test.want[idx]) {
+					t.Errorf("block #%d is not in the knowledge base:\n%s", idx, knowledgeBase)
+				}
+			}
+		})
+	}
+}
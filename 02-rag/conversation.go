@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/openai/openai-go"
+)
+
+// ChatTurn is a message of the conversation history.
+type ChatTurn struct {
+	Role    string // user or assistant
+	Content string
+}
+
+// HistoryMessages converts the history of the conversation to chat messages.
+func HistoryMessages(history []ChatTurn) []openai.ChatCompletionMessageParamUnion {
+	messages := []openai.ChatCompletionMessageParamUnion{}
+	for _, turn := range history {
+		if turn.Role == "assistant" {
+			messages = append(messages, openai.AssistantMessage(turn.Content))
+		} else {
+			messages = append(messages, openai.UserMessage(turn.Content))
+		}
+	}
+	return messages
+}
+
+const condenseInstructions = `
+	Given the conversation history, the sources cited in the last answer and a follow-up question,
+	rewrite the follow-up question as a standalone question that can be understood without the history.
+	Replace the references (like "it", "the second one", "tell me more") by what they refer to.
+	If the follow-up question is already standalone, return it unchanged.
+	Answer ONLY with the standalone question.
+	`
+
+// Conversation is a RAG chat: every turn retrieves fresh context,
+// and the answer is generated with the history of the conversation.
+type Conversation struct {
+	RAG        *RAG
+	Filters    []Filter
+	MaxHistory int // maximum number of messages kept in the history (0 = no limit)
+
+	History []ChatTurn
+	Cited   []RetrievedChunk // the blocks of the knowledge base of the last answer
+}
+
+// NewConversation creates a conversation keeping the last 10 messages.
+func NewConversation(rag *RAG, filters []Filter) *Conversation {
+	return &Conversation{RAG: rag, Filters: filters, MaxHistory: 10}
+}
+
+// Condense rewrites the question as a standalone query, using the history and the cited sources,
+// so the retrieval works with follow-up questions.
+func (c *Conversation) Condense(ctx context.Context, question string) (string, error) {
+	if len(c.History) == 0 {
+		return question, nil
+	}
+
+	var prompt strings.Builder
+	prompt.WriteString("CONVERSATION HISTORY:\n")
+	for _, turn := range c.History {
+		prompt.WriteString(turn.Role + ": " + turn.Content + "\n")
+	}
+	if len(c.Cited) > 0 {
+		prompt.WriteString("\nSOURCES CITED IN THE LAST ANSWER:\n")
+		for idx, block := range c.Cited {
+			prompt.WriteString(fmt.Sprintf("[%d] %s\n", idx+1, excerpt(block.Content, 200)))
+		}
+	}
+	prompt.WriteString("\nFOLLOW-UP QUESTION: " + question)
+
+	standalone, err := c.RAG.Complete(ctx, []openai.ChatCompletionMessageParamUnion{
+		openai.SystemMessage(condenseInstructions),
+		openai.UserMessage(prompt.String()),
+	}, 0.0)
+	if err != nil {
+		return "", err
+	}
+	standalone = strings.TrimSpace(standalone)
+	if standalone == "" {
+		return question, nil
+	}
+	return standalone, nil
+}
+
+// Ask answers a question of the conversation and streams the answer to the writer:
+//   - the question is condensed into a standalone query,
+//   - fresh context is retrieved with this query,
+//   - the previously cited blocks are kept in the knowledge base (for the follow-ups),
+//   - the answer is generated with the history.
+//
+// It returns the standalone query.
+func (c *Conversation) Ask(ctx context.Context, question string, out io.Writer) (string, error) {
+	query, err := c.Condense(ctx, question)
+	if err != nil {
+		return "", err
+	}
+
+	similarities, err := c.RAG.Search(ctx, query, c.Filters)
+	if err != nil {
+		return query, err
+	}
+	similarities = appendMissingChunks(similarities, c.Cited)
+
+	var answer strings.Builder
+	blocks, err := c.RAG.AnswerWithHistory(ctx, question, c.History, similarities, io.MultiWriter(out, &answer))
+	if err != nil {
+		return query, err
+	}
+
+	c.Cited = blocks
+	c.History = append(c.History,
+		ChatTurn{Role: "user", Content: question},
+		ChatTurn{Role: "assistant", Content: answer.String()},
+	)
+	if c.MaxHistory > 0 && len(c.History) > c.MaxHistory {
+		c.History = c.History[len(c.History)-c.MaxHistory:]
+	}
+	return query, nil
+}
+
+// appendMissingChunks appends the chunks (or merged blocks) that are not already in the list.
+// The overlapping ones are merged later by BuildKnowledgeBase.
+func appendMissingChunks(chunks []RetrievedChunk, others []RetrievedChunk) []RetrievedChunk {
+	result := append([]RetrievedChunk{}, chunks...)
+	for _, other := range others {
+		if !slices.ContainsFunc(chunks, func(chunk RetrievedChunk) bool { return chunk.ID == other.ID }) {
+			result = append(result, other)
+		}
+	}
+	return result
+}
+
+func excerpt(text string, size int) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if len(text) <= size {
+		return text
+	}
+	return text[:size] + "..."
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"maps"
+	"slices"
+	"strconv"
+)
+
+// DedupPolicy defines the detection of the duplicate chunks at ingest time.
+type DedupPolicy struct {
+	Enabled   bool
+	Threshold float64 // minimum cosine similarity of two near-duplicate chunks
+}
+
+// DedupPolicyFromEnv reads the deduplication policy from the environment variables.
+func DedupPolicyFromEnv() DedupPolicy {
+	threshold, err := strconv.ParseFloat(GetEnv("RAG_DEDUP_THRESHOLD", "0.95"), 64)
+	if err != nil {
+		threshold = 0.95
+	}
+	return DedupPolicy{
+		Enabled:   GetEnv("RAG_DEDUP", "false") == "true",
+		Threshold: threshold,
+	}
+}
+
+// DuplicateChunk is a chunk merged into a canonical chunk.
+type DuplicateChunk struct {
+	Key        string  `json:"key"`
+	Source     string  `json:"source"`
+	Canonical  string  `json:"canonical"`  // the key of the canonical chunk
+	Similarity float64 `json:"similarity"` // 1 for an exact duplicate
+	Exact      bool    `json:"exact"`
+}
+
+// DeduplicateChunks detects the duplicate chunks:
+//   - the exact duplicates have the same normalized content (same hash),
+//   - the near duplicates have embeddings with a cosine similarity above the threshold of the policy.
+//
+// The first chunk is the canonical one: the duplicates are merged into it
+// (it references their sources and gets their tags), and they are recorded in the report.
+// It returns the canonical chunks, with their embeddings.
+// A chunk whose embedding can't be created is recorded as a failure.
+func (r *RAG) DeduplicateChunks(ctx context.Context, chunks []pendingChunk, report *IngestReport) ([]pendingChunk, error) {
+	canonical := []pendingChunk{}
+	byHash := map[[32]byte]int{}
+
+	for _, chunk := range chunks {
+		hash := sha256.Sum256([]byte(NormalizeText(chunk.Chunk.Content)))
+		if idx, ok := byHash[hash]; ok {
+			mergeDuplicate(&canonical[idx], chunk, 1, true, report)
+			continue
+		}
+
+		attempts, err := r.withRetry(ctx, chunk.Key, func() error {
+			embedding, err := r.CreateEmbedding(ctx, chunk.Chunk.Content)
+			chunk.Embedding = embedding
+			return err
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return canonical, ctx.Err()
+			}
+			report.Failures = append(report.Failures, ChunkFailure{Key: chunk.Key, Chunk: chunk.Chunk, Error: "creating embedding: " + err.Error(), Attempts: attempts})
+			continue
+		}
+
+		best, bestSimilarity := -1, 0.0
+		for idx, other := range canonical {
+			if similarity := 1 - Distance("COSINE", chunk.Embedding, other.Embedding); similarity > bestSimilarity {
+				best, bestSimilarity = idx, similarity
+			}
+		}
+		if best >= 0 && bestSimilarity >= r.Dedup.Threshold {
+			mergeDuplicate(&canonical[best], chunk, bestSimilarity, false, report)
+			byHash[hash] = best
+			continue
+		}
+
+		byHash[hash] = len(canonical)
+		canonical = append(canonical, chunk)
+	}
+	return canonical, nil
+}
+
+// mergeDuplicate adds the source and the tags of the duplicate to the canonical chunk.
+func mergeDuplicate(canonical *pendingChunk, duplicate pendingChunk, similarity float64, exact bool, report *IngestReport) {
+	report.Duplicates = append(report.Duplicates, DuplicateChunk{
+		Key:        duplicate.Key,
+		Source:     duplicate.Chunk.Source,
+		Canonical:  canonical.Key,
+		Similarity: similarity,
+		Exact:      exact,
+	})
+
+	source := duplicate.Chunk.Source
+	if source != canonical.Chunk.Source && !slices.Contains(canonical.Chunk.Sources, source) {
+		canonical.Chunk.Sources = append(canonical.Chunk.Sources, source)
+	}
+
+	// the metadata map is shared by the chunks of a section, so it is copied before the change
+	metadata := maps.Clone(canonical.Chunk.Metadata)
+	if metadata == nil {
+		metadata = map[string]string{}
+	}
+	AddTags(metadata, SplitTags(duplicate.Chunk.Metadata["tags"])...)
+	canonical.Chunk.Metadata = metadata
+}
+
+// ExactDuplicates returns the number of exact duplicates of the report.
+func (r IngestReport) ExactDuplicates() int {
+	count := 0
+	for _, duplicate := range r.Duplicates {
+		if duplicate.Exact {
+			count++
+		}
+	}
+	return count
+}
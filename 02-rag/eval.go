@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//go:embed eval/relevance.json
+var defaultEvalSet []byte
+
+// EvalQuestion is a question of the evaluation set, labelled with the passages answering it.
+type EvalQuestion struct {
+	Question string         `json:"question"`
+	Relevant []RelevantText `json:"relevant"`
+}
+
+// RelevantText is a passage of a source document answering a question:
+// a retrieved chunk is relevant when it comes from the source and contains the text
+// (short evidence, e.g. a name or a figure, so it is not split by the chunking).
+type RelevantText struct {
+	Source   string `json:"source"`   // the file name of the document, e.g. hawaiian-pizza-knowledge-base.md
+	Contains string `json:"contains"` // matched ignoring the case and the whitespace
+}
+
+// LoadEvalSet reads the labelled questions of a JSON file.
+// With an empty path, the evaluation set embedded in the program (eval/relevance.json) is used.
+func LoadEvalSet(path string) ([]EvalQuestion, error) {
+	data := defaultEvalSet
+	if path != "" {
+		var err error
+		if data, err = os.ReadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	questions := []EvalQuestion{}
+	if err := json.Unmarshal(data, &questions); err != nil {
+		return nil, err
+	}
+	for idx, question := range questions {
+		if question.Question == "" || len(question.Relevant) == 0 {
+			return nil, fmt.Errorf("question #%d: the question and its relevant passages are required", idx+1)
+		}
+		for _, relevant := range question.Relevant {
+			if relevant.Source == "" || strings.TrimSpace(relevant.Contains) == "" {
+				return nil, fmt.Errorf("question #%d: a relevant passage needs a source and a text", idx+1)
+			}
+		}
+	}
+	return questions, nil
+}
+
+// IsRelevant reports whether a retrieved chunk is one of the labelled passages of the question
+// (the sources of the merged duplicates count too).
+func (q EvalQuestion) IsRelevant(chunk RetrievedChunk) bool {
+	content := strings.ToLower(NormalizeText(chunk.Content))
+	for _, relevant := range q.Relevant {
+		if !strings.Contains(content, strings.ToLower(NormalizeText(relevant.Contains))) {
+			continue
+		}
+		for _, source := range append([]string{chunk.Source}, chunk.Sources...) {
+			if filepath.Base(source) == relevant.Source {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// RetrievalResult sums up the retrieval of the evaluation set with a query mode.
+type RetrievalResult struct {
+	Mode      string
+	Questions int
+	Hits      int     // questions with a relevant chunk in the retrieved chunks
+	MRR       float64 // mean reciprocal rank of the first relevant chunk
+}
+
+// HitRate returns the ratio of questions with a relevant chunk retrieved (hit@k).
+func (r RetrievalResult) HitRate() float64 {
+	if r.Questions == 0 {
+		return 0
+	}
+	return float64(r.Hits) / float64(r.Questions)
+}
+
+// EvaluateRetrieval searches the chunks of every question of the evaluation set with the HyDE mode,
+// and looks for the labelled relevant passages in the retrieved chunks.
+func (r *RAG) EvaluateRetrieval(ctx context.Context, questions []EvalQuestion, mode string, filters []Filter) (RetrievalResult, error) {
+	rag := *r
+	rag.HyDE = mode
+	result := RetrievalResult{Mode: mode, Questions: len(questions)}
+	for _, question := range questions {
+		chunks, err := rag.Search(ctx, question.Question, filters)
+		if err != nil {
+			return result, err
+		}
+		for rank, chunk := range chunks {
+			if question.IsRelevant(chunk) {
+				result.Hits++
+				result.MRR += 1 / float64(rank+1)
+				break
+			}
+		}
+	}
+	if result.Questions > 0 {
+		result.MRR /= float64(result.Questions)
+	}
+	return result, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// filterFields maps the names usable in a filter expression to the TAG fields of the index.
+var filterFields = map[string]string{
+	"tag":    "tags",
+	"tags":   "tags",
+	"lang":   "lang",
+	"source": "source_name",
+}
+
+// Filter restricts the vector search to the chunks whose TAG field matches one of the values.
+type Filter struct {
+	Field  string
+	Values []string
+}
+
+// ParseFilters parses filter expressions:
+//   - "faq": the chunks tagged with faq
+//   - "lang:fr": the chunks in French
+//   - "source:popular-questions-and-answers.md": the chunks of a document
+//   - "tags:faq|recipes": the chunks tagged with faq or recipes
+//
+// All the filters must match (AND), the values of a filter are alternatives (OR).
+func ParseFilters(expressions []string) ([]Filter, error) {
+	filters := []Filter{}
+	for _, expression := range expressions {
+		expression = strings.TrimSpace(expression)
+		if expression == "" {
+			continue
+		}
+		name, values, found := strings.Cut(expression, ":")
+		if !found {
+			name, values = "tags", expression
+		}
+		field, ok := filterFields[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			return nil, fmt.Errorf("unknown filter field %q (use tags, lang or source)", name)
+		}
+		filter := Filter{Field: field}
+		for _, value := range strings.Split(values, "|") {
+			if value = strings.TrimSpace(value); value != "" {
+				filter.Values = append(filter.Values, value)
+			}
+		}
+		if len(filter.Values) == 0 {
+			return nil, fmt.Errorf("empty filter %q", expression)
+		}
+		filters = append(filters, filter)
+	}
+	return filters, nil
+}
+
+// FilterQuery returns the pre-filter of the KNN query: "*" when there is no filter,
+// or something like "(@tags:{faq} @lang:{fr})".
+func FilterQuery(filters []Filter) string {
+	if len(filters) == 0 {
+		return "*"
+	}
+	clauses := []string{}
+	for _, filter := range filters {
+		values := make([]string, len(filter.Values))
+		for idx, value := range filter.Values {
+			values[idx] = EscapeTagValue(NormalizeTag(value))
+		}
+		clauses = append(clauses, fmt.Sprintf("@%s:{%s}", filter.Field, strings.Join(values, "|")))
+	}
+	return "(" + strings.Join(clauses, " ") + ")"
+}
+
+// NormalizeTag lower-cases and trims a tag, so the stored tags and the filters match.
+func NormalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimSpace(tag))
+}
+
+// EscapeTagValue escapes the punctuation and the spaces of a TAG value for a RediSearch query.
+func EscapeTagValue(value string) string {
+	var escaped strings.Builder
+	for _, r := range value {
+		if strings.ContainsRune(",.<>{}[]\"':;!@#$%^&*()-+=~|/\\ ", r) {
+			escaped.WriteRune('\\')
+		}
+		escaped.WriteRune(r)
+	}
+	return escaped.String()
+}
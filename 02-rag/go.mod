@@ -5,6 +5,7 @@ go 1.24.0
 require (
 	github.com/joho/godotenv v1.5.1
 	github.com/openai/openai-go v0.1.0-beta.9
+	golang.org/x/net v0.40.0
 )
 
 require (
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/openai/openai-go"
+)
+
+// GroundingPolicy defines the verification of the answers against the retrieved context.
+type GroundingPolicy struct {
+	Enabled    bool
+	JudgeModel string  // the model checking the claims (the chat model when empty)
+	Threshold  float64 // minimum ratio of supported claims
+	Regenerate bool    // regenerate the answer with stricter instructions when the score is below the threshold
+}
+
+// GroundingPolicyFromEnv reads the grounding policy from the environment variables.
+func GroundingPolicyFromEnv() GroundingPolicy {
+	threshold, err := strconv.ParseFloat(GetEnv("RAG_GROUNDEDNESS_THRESHOLD", "0.8"), 64)
+	if err != nil {
+		threshold = 0.8
+	}
+	return GroundingPolicy{
+		Enabled:    GetEnv("RAG_GROUNDEDNESS_CHECK", "false") == "true",
+		JudgeModel: GetEnv("RAG_JUDGE_MODEL", ""),
+		Threshold:  threshold,
+		Regenerate: GetEnv("RAG_GROUNDEDNESS_REGENERATE", "false") == "true",
+	}
+}
+
+const judgeInstructions = `
+	You are a strict fact checker.
+	You receive a KNOWLEDGE BASE and a CLAIM.
+	Answer SUPPORTED if the claim is stated in, or directly follows from, the knowledge base.
+	Answer UNSUPPORTED if the claim is not in the knowledge base or contradicts it.
+	Opinions, greetings and puns that don't state any fact are SUPPORTED.
+	Answer ONLY with SUPPORTED or UNSUPPORTED.
+	`
+
+const strictInstructions = `
+	YOUR PREVIOUS ANSWER CONTAINED CLAIMS THAT ARE NOT IN THE KNOWLEDGE BASE.
+	State ONLY facts that are written in the knowledge base.
+	If the knowledge base doesn't contain the answer, say that you don't know.
+	`
+
+// ClaimVerdict is the verdict of the judge model about a claim of the answer.
+type ClaimVerdict struct {
+	Claim     string `json:"claim"`
+	Supported bool   `json:"supported"`
+}
+
+// GroundednessReport sums up the verification of an answer.
+type GroundednessReport struct {
+	Verdicts    []ClaimVerdict `json:"verdicts"`
+	Score       float64        `json:"score"`       // ratio of supported claims (1 when there is no claim)
+	Regenerated bool           `json:"regenerated"` // the report is the one of the regenerated answer
+}
+
+// Unsupported returns the claims that are not supported by the retrieved context.
+func (g GroundednessReport) Unsupported() []string {
+	claims := []string{}
+	for _, verdict := range g.Verdicts {
+		if !verdict.Supported {
+			claims = append(claims, verdict.Claim)
+		}
+	}
+	return claims
+}
+
+// SplitClaims splits an answer into claims: its sentences and list items.
+// The fragments without enough words to state a fact (e.g. "Great question!") are skipped.
+func SplitClaims(answer string) []string {
+	claims := []string{}
+	for _, line := range strings.Split(answer, "\n") {
+		line = strings.TrimLeft(strings.TrimSpace(line), "-*•#> ")
+		sentence := []rune{}
+		for idx, char := range []rune(line) {
+			sentence = append(sentence, char)
+			end := strings.ContainsRune(".!?", char) &&
+				(idx == len([]rune(line))-1 || unicode.IsSpace([]rune(line)[idx+1]))
+			if end {
+				claims = appendClaim(claims, string(sentence))
+				sentence = sentence[:0]
+			}
+		}
+		claims = appendClaim(claims, string(sentence))
+	}
+	return claims
+}
+
+func appendClaim(claims []string, sentence string) []string {
+	sentence = strings.TrimSpace(sentence)
+	if len(strings.Fields(sentence)) < 4 {
+		return claims
+	}
+	return append(claims, sentence)
+}
+
+// JudgeClaim asks the judge model whether the claim is supported by the knowledge base.
+func (r *RAG) JudgeClaim(ctx context.Context, claim, knowledgeBase string) (bool, error) {
+	judge := *r
+	if r.Grounding.JudgeModel != "" {
+		judge.ChatModel = r.Grounding.JudgeModel
+	}
+	verdict, err := judge.Complete(ctx, []openai.ChatCompletionMessageParamUnion{
+		openai.SystemMessage(judgeInstructions),
+		openai.UserMessage("KNOWLEDGE BASE:\n" + knowledgeBase + "\nCLAIM: " + claim),
+	}, 0.0)
+	if err != nil {
+		return false, err
+	}
+	verdict = strings.ToUpper(verdict)
+	return strings.Contains(verdict, "SUPPORTED") && !strings.Contains(verdict, "UNSUPPORTED"), nil
+}
+
+// CheckGroundedness splits the answer into claims, and asks the judge model
+// whether each claim is supported by the blocks of the knowledge base used to answer.
+func (r *RAG) CheckGroundedness(ctx context.Context, answer string, blocks []RetrievedChunk) (GroundednessReport, error) {
+	var knowledgeBase strings.Builder
+	for idx, block := range blocks {
+		knowledgeBase.WriteString(delimiter(idx+1, block))
+		knowledgeBase.WriteString(strings.TrimSpace(block.Content))
+		knowledgeBase.WriteString("\n\n")
+	}
+
+	report := GroundednessReport{Score: 1}
+	supported := 0
+	for _, claim := range SplitClaims(answer) {
+		ok, err := r.JudgeClaim(ctx, claim, knowledgeBase.String())
+		if err != nil {
+			return report, err
+		}
+		report.Verdicts = append(report.Verdicts, ClaimVerdict{Claim: claim, Supported: ok})
+		if ok {
+			supported++
+		}
+	}
+	if len(report.Verdicts) > 0 {
+		report.Score = float64(supported) / float64(len(report.Verdicts))
+	}
+	return report, nil
+}
+
+// GroundednessSummary returns a short description of the report, with the unsupported claims.
+func GroundednessSummary(report GroundednessReport, threshold float64) string {
+	var summary strings.Builder
+	icon := "✅"
+	if report.Score < threshold {
+		icon = "⚠️ "
+	}
+	fmt.Fprintf(&summary, "%s Groundedness: %.0f%% (%d/%d claims supported)",
+		icon, report.Score*100, len(report.Verdicts)-len(report.Unsupported()), len(report.Verdicts))
+	for _, claim := range report.Unsupported() {
+		summary.WriteString("\n   ❌ " + claim)
+	}
+	return summary.String()
+}
+
+// AskGrounded answers the question like Ask, then checks the groundedness of the answer.
+// When the score is below the threshold and the policy allows it, the answer is regenerated
+// with stricter instructions (and streamed to the writer after the first one), then checked again.
+func (r *RAG) AskGrounded(ctx context.Context, question string, filters []Filter, out io.Writer) ([]RetrievedChunk, GroundednessReport, error) {
+	similarities, err := r.Search(ctx, question, filters)
+	if err != nil {
+		return nil, GroundednessReport{}, err
+	}
+
+	answer := &captureWriter{out: out}
+	blocks, err := r.Answer(ctx, question, similarities, answer)
+	if err != nil {
+		return blocks, GroundednessReport{}, err
+	}
+	report, err := r.CheckGroundedness(ctx, answer.text.String(), blocks)
+	if err != nil || report.Score >= r.Grounding.Threshold || !r.Grounding.Regenerate {
+		return blocks, report, err
+	}
+
+	strict := *r
+	strict.SystemInstructions = r.SystemInstructions + strictInstructions +
+		"Don't repeat these unsupported claims:\n- " + strings.Join(report.Unsupported(), "\n- ") + "\n"
+	fmt.Fprintf(out, "\n\n🔁 Regenerated answer (groundedness: %.0f%%):\n", report.Score*100)
+
+	answer.text.Reset()
+	blocks, err = strict.Answer(ctx, question, similarities, answer)
+	if err != nil {
+		return blocks, report, err
+	}
+	report, err = r.CheckGroundedness(ctx, answer.text.String(), blocks)
+	report.Regenerated = true
+	return blocks, report, err
+}
+
+// captureWriter writes to the output and keeps a copy of the text,
+// the output is still flushed (e.g. the response of the query server).
+type captureWriter struct {
+	out  io.Writer
+	text strings.Builder
+}
+
+func (w *captureWriter) Write(p []byte) (int, error) {
+	w.text.Write(p)
+	return w.out.Write(p)
+}
+
+func (w *captureWriter) Flush() {
+	if flusher, ok := w.out.(interface{ Flush() }); ok {
+		flusher.Flush()
+	}
+}
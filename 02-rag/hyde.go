@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/openai/openai-go"
+)
+
+// HyDE (Hypothetical Document Embeddings):
+// a short question doesn't embed like the answer-style text of the documents,
+// so the chat model first writes a hypothetical answer, and the search is done with its embedding.
+const (
+	HyDEOff     = "off"     // search with the embedding of the question
+	HyDEAnswer  = "answer"  // search with the embedding of the hypothetical answer
+	HyDEAverage = "average" // search with the average of the embeddings of the question and of the hypothetical answer
+)
+
+const hydeInstructions = `
+	Write a short passage (2 or 3 sentences) that answers the question,
+	in the style of an encyclopedia article or of a FAQ about pizza.
+	Don't say that you don't know: write the most plausible answer, it is only used to search the documents.
+	Answer ONLY with the passage.
+	`
+
+// ValidateHyDE checks the HyDE mode.
+func ValidateHyDE(mode string) error {
+	switch mode {
+	case HyDEOff, HyDEAnswer, HyDEAverage:
+		return nil
+	default:
+		return fmt.Errorf("unknown HyDE mode %q (use off, answer or average)", mode)
+	}
+}
+
+// HypotheticalAnswer asks the chat model for a short hypothetical answer to the question.
+func (r *RAG) HypotheticalAnswer(ctx context.Context, question string) (string, error) {
+	answer, err := r.Complete(ctx, []openai.ChatCompletionMessageParamUnion{
+		openai.SystemMessage(hydeInstructions),
+		openai.UserMessage(question),
+	}, 0.0)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(answer), nil
+}
+
+// QueryEmbedding returns the embedding used to search the chunks similar to the question,
+// according to the HyDE mode of the RAG.
+// If the hypothetical answer can't be generated, the embedding of the question is used.
+func (r *RAG) QueryEmbedding(ctx context.Context, question string) ([]float32, error) {
+	if r.HyDE == "" || r.HyDE == HyDEOff {
+		return r.CreateEmbedding(ctx, question)
+	}
+
+	answer, err := r.HypotheticalAnswer(ctx, question)
+	if err != nil || answer == "" {
+		return r.CreateEmbedding(ctx, question)
+	}
+	answerEmbedding, err := r.CreateEmbedding(ctx, answer)
+	if err != nil {
+		return nil, err
+	}
+	if r.HyDE == HyDEAnswer {
+		return answerEmbedding, nil
+	}
+
+	questionEmbedding, err := r.CreateEmbedding(ctx, question)
+	if err != nil {
+		return nil, err
+	}
+	return AverageVectors(questionEmbedding, answerEmbedding, r.Index.Normalize), nil
+}
+
+// AverageVectors returns the average of two vectors of the same dimension.
+// The vectors are normalized first, so they have the same weight,
+// and the average is normalized when the index uses normalized vectors.
+func AverageVectors(a, b []float32, normalize bool) []float32 {
+	a = NormalizeVector(append([]float32(nil), a...))
+	b = NormalizeVector(append([]float32(nil), b...))
+	average := make([]float32, len(a))
+	for idx := range average {
+		average[idx] = (a[idx] + b[idx]) / 2
+	}
+	if normalize {
+		return NormalizeVector(average)
+	}
+	return average
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// IndexSettings describes how the vectors are indexed and compared.
+type IndexSettings struct {
+	Algorithm      string `json:"algorithm"`       // HNSW or FLAT
+	DistanceMetric string `json:"distance_metric"` // COSINE, IP or L2
+	Dim            int    `json:"dim"`             // 1024 for mxbai-embed-large
+	M              int    `json:"m"`               // HNSW: maximum number of outgoing edges per node (0 = Redis default)
+	EFConstruction int    `json:"ef_construction"` // HNSW: number of candidates explored when building the graph (0 = Redis default)
+	EFRuntime      int    `json:"ef_runtime"`      // HNSW: number of candidates explored during the KNN search (0 = Redis default)
+	Normalize      bool   `json:"normalize"`       // normalize the vectors (unit length) before storing and searching them
+}
+
+// IndexSettingsFromEnv reads the index settings from the environment variables.
+func IndexSettingsFromEnv() IndexSettings {
+	return IndexSettings{
+		Algorithm:      strings.ToUpper(GetEnv("RAG_INDEX_ALGORITHM", "HNSW")),
+		DistanceMetric: strings.ToUpper(GetEnv("RAG_DISTANCE_METRIC", "L2")),
+		Dim:            GetEnvInt("RAG_VECTOR_DIM", 1024),
+		M:              GetEnvInt("RAG_HNSW_M", 0),
+		EFConstruction: GetEnvInt("RAG_HNSW_EF_CONSTRUCTION", 0),
+		EFRuntime:      GetEnvInt("RAG_HNSW_EF_RUNTIME", 0),
+		Normalize:      GetEnv("RAG_NORMALIZE_VECTORS", "false") == "true",
+	}
+}
+
+// Validate checks the algorithm and the distance metric.
+func (s IndexSettings) Validate() error {
+	switch s.Algorithm {
+	case "HNSW", "FLAT":
+	default:
+		return fmt.Errorf("unknown index algorithm %q (use HNSW or FLAT)", s.Algorithm)
+	}
+	switch s.DistanceMetric {
+	case "COSINE", "IP", "L2":
+	default:
+		return fmt.Errorf("unknown distance metric %q (use COSINE, IP or L2)", s.DistanceMetric)
+	}
+	if s.Dim <= 0 {
+		return fmt.Errorf("invalid vector dimension %d", s.Dim)
+	}
+	return nil
+}
+
+// String returns a short description of the settings, e.g. "HNSW/COSINE (M=16, EF_CONSTRUCTION=200)".
+func (s IndexSettings) String() string {
+	description := s.Algorithm + "/" + s.DistanceMetric
+	if s.Algorithm == "HNSW" {
+		options := []string{}
+		if s.M > 0 {
+			options = append(options, fmt.Sprintf("M=%d", s.M))
+		}
+		if s.EFConstruction > 0 {
+			options = append(options, fmt.Sprintf("EF_CONSTRUCTION=%d", s.EFConstruction))
+		}
+		if s.EFRuntime > 0 {
+			options = append(options, fmt.Sprintf("EF_RUNTIME=%d", s.EFRuntime))
+		}
+		if len(options) > 0 {
+			description += " (" + strings.Join(options, ", ") + ")"
+		}
+	}
+	if s.Normalize {
+		description += " normalized"
+	}
+	return description
+}
+
+// VectorArgs returns the schema arguments of the embedding field.
+func (s IndexSettings) VectorArgs() *redis.FTVectorArgs {
+	if s.Algorithm == "FLAT" {
+		return &redis.FTVectorArgs{
+			FlatOptions: &redis.FTFlatOptions{
+				Dim:            s.Dim,
+				DistanceMetric: s.DistanceMetric,
+				Type:           "FLOAT32",
+			},
+		}
+	}
+	return &redis.FTVectorArgs{
+		HNSWOptions: &redis.FTHNSWOptions{
+			Dim:                    s.Dim,
+			DistanceMetric:         s.DistanceMetric,
+			Type:                   "FLOAT32",
+			MaxEdgesPerNode:        s.M,
+			MaxAllowedEdgesPerNode: s.EFConstruction,
+			EFRunTime:              s.EFRuntime,
+		},
+	}
+}
+
+// NormalizeVector scales a vector to unit length (the vector is modified in place).
+// With normalized vectors, COSINE, IP and L2 give the same ranking.
+func NormalizeVector(vector []float32) []float32 {
+	var norm float64
+	for _, value := range vector {
+		norm += float64(value) * float64(value)
+	}
+	norm = math.Sqrt(norm)
+	if norm == 0 {
+		return vector
+	}
+	for idx, value := range vector {
+		vector[idx] = float32(float64(value) / norm)
+	}
+	return vector
+}
+
+// Distance computes the distance between two vectors like Redis does for the metric:
+// the lower, the more similar.
+func Distance(metric string, a, b []float32) float64 {
+	var dot, normA, normB, l2 float64
+	for idx := range a {
+		x, y := float64(a[idx]), float64(b[idx])
+		dot += x * y
+		normA += x * x
+		normB += y * y
+		l2 += (x - y) * (x - y)
+	}
+	switch metric {
+	case "COSINE":
+		if normA == 0 || normB == 0 {
+			return 1
+		}
+		return 1 - dot/(math.Sqrt(normA)*math.Sqrt(normB))
+	case "IP":
+		return 1 - dot
+	default:
+		return l2
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// IngestPolicy defines what happens when a chunk can't be embedded or stored.
+type IngestPolicy struct {
+	Retries         int           // number of retries of a failed chunk
+	Backoff         time.Duration // delay before the first retry (doubled after each retry)
+	MaxFailureRatio float64       // the ingestion fails when the ratio of failed chunks is above
+}
+
+// IngestPolicyFromEnv reads the ingestion policy from the environment variables.
+func IngestPolicyFromEnv() IngestPolicy {
+	maxFailureRatio, err := strconv.ParseFloat(GetEnv("RAG_INGEST_MAX_FAILURE_RATIO", "0.1"), 64)
+	if err != nil {
+		maxFailureRatio = 0.1
+	}
+	return IngestPolicy{
+		Retries:         GetEnvInt("RAG_INGEST_RETRIES", 3),
+		Backoff:         time.Duration(GetEnvInt("RAG_INGEST_BACKOFF_MS", 500)) * time.Millisecond,
+		MaxFailureRatio: maxFailureRatio,
+	}
+}
+
+// ChunkFailure is a chunk that could not be indexed.
+// It contains the whole chunk, so the ingestion can be resumed without reading the documents again.
+type ChunkFailure struct {
+	Key      string `json:"key"`
+	Chunk    Chunk  `json:"chunk"`
+	Error    string `json:"error"`
+	Attempts int    `json:"attempts"`
+}
+
+// IngestReport sums up an ingestion.
+type IngestReport struct {
+	Indexed    int              `json:"indexed"`
+	Failures   []ChunkFailure   `json:"failures"`
+	Duplicates []DuplicateChunk `json:"duplicates,omitempty"` // the chunks merged into a canonical chunk
+}
+
+// Total returns the number of chunks processed.
+func (r IngestReport) Total() int {
+	return r.Indexed + len(r.Failures)
+}
+
+// FailureRatio returns the ratio of failed chunks.
+func (r IngestReport) FailureRatio() float64 {
+	if r.Total() == 0 {
+		return 0
+	}
+	return float64(len(r.Failures)) / float64(r.Total())
+}
+
+// Add adds the results of another report.
+func (r *IngestReport) Add(other IngestReport) {
+	r.Indexed += other.Indexed
+	r.Failures = append(r.Failures, other.Failures...)
+	r.Duplicates = append(r.Duplicates, other.Duplicates...)
+}
+
+// WriteIngestReport writes the report (JSON) to a file.
+func WriteIngestReport(path string, report IngestReport) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// ReadIngestReport reads a report written by WriteIngestReport.
+func ReadIngestReport(path string) (IngestReport, error) {
+	var report IngestReport
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return report, err
+	}
+	err = json.Unmarshal(data, &report)
+	return report, err
+}
+
+// StoreChunkWithRetry stores a chunk (see StoreChunk), and retries with backoff when it fails.
+// It returns the number of attempts.
+func (r *RAG) StoreChunkWithRetry(ctx context.Context, key string, chunk Chunk) (int, error) {
+	return r.withRetry(ctx, key, func() error {
+		return r.StoreChunk(ctx, key, chunk)
+	})
+}
+
+// withRetry calls fn (e.g. to embed or store the chunk of the key) until it succeeds,
+// with the retries and the backoff of the ingest policy. It returns the number of attempts.
+func (r *RAG) withRetry(ctx context.Context, key string, fn func() error) (int, error) {
+	delay := r.Ingest.Backoff
+	attempts := r.Ingest.Retries + 1
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if err = fn(); err == nil {
+			return attempt, nil
+		}
+		if attempt < attempts {
+			log.Printf("⏳ Retrying %s in %v (attempt %d/%d): %v", key, delay, attempt, attempts, err)
+			select {
+			case <-ctx.Done():
+				return attempt, ctx.Err()
+			case <-time.After(delay):
+			}
+			delay *= 2
+		}
+	}
+	return attempts, err
+}
+
+// ResumeIngest retries the failed chunks of a previous report.
+// It returns a new report, with the chunks that failed again.
+func (r *RAG) ResumeIngest(ctx context.Context, previous IngestReport) IngestReport {
+	report := IngestReport{}
+	for _, failure := range previous.Failures {
+		attempts, err := r.StoreChunkWithRetry(ctx, failure.Key, failure.Chunk)
+		if err != nil {
+			report.Failures = append(report.Failures, ChunkFailure{
+				Key:      failure.Key,
+				Chunk:    failure.Chunk,
+				Error:    err.Error(),
+				Attempts: failure.Attempts + attempts,
+			})
+			continue
+		}
+		report.Indexed++
+	}
+	return report
+}
+
+// CheckIngestReport writes the report when some chunks failed (or removes the previous one),
+// and returns an error when the failure ratio is above the maximum of the policy.
+func CheckIngestReport(report IngestReport, reportPath string, policy IngestPolicy) error {
+	if len(report.Failures) == 0 {
+		os.Remove(reportPath)
+		return nil
+	}
+	if err := WriteIngestReport(reportPath, report); err != nil {
+		log.Println("😡 Error writing the failure report:", err)
+	} else {
+		log.Println("📝", len(report.Failures), "failed chunks written to", reportPath, "(use ingest --resume to retry them)")
+	}
+	if report.FailureRatio() > policy.MaxFailureRatio {
+		return fmt.Errorf("%d/%d chunks failed (%.1f%%, the maximum is %.1f%%)",
+			len(report.Failures), report.Total(), report.FailureRatio()*100, policy.MaxFailureRatio*100)
+	}
+	return nil
+}
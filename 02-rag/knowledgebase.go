@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// knowledgeBasesKey is the Redis hash of the knowledge bases (name => JSON definition).
+const knowledgeBasesKey = metadataPrefix + "knowledge_bases"
+
+// metadataPrefix is the key prefix of the keys of the RAG itself (e.g. the knowledge bases).
+const metadataPrefix = "rag:"
+
+var knowledgeBaseName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// KnowledgeBase is a named set of documents with its own index, embedding model and persona,
+// e.g. one knowledge base per franchise or per language.
+type KnowledgeBase struct {
+	Name               string        `json:"name"`
+	IndexName          string        `json:"index_name"`
+	KeyPrefix          string        `json:"key_prefix"`
+	DocsDir            string        `json:"docs_dir"`
+	EmbeddingsModel    string        `json:"embeddings_model"`
+	SystemInstructions string        `json:"system_instructions"` // the persona of the assistant
+	Index              IndexSettings `json:"index"`
+}
+
+// NewKnowledgeBase creates the definition of a knowledge base with the settings of the RAG,
+// an index named <name>_idx, the kb:<name>: key prefix and the /kb/<name> docs directory
+// (outside of the docs directory of the default index, which is walked recursively).
+func NewKnowledgeBase(name string, rag *RAG) KnowledgeBase {
+	return KnowledgeBase{
+		Name:               name,
+		IndexName:          name + "_idx",
+		KeyPrefix:          "kb:" + name + ":",
+		DocsDir:            "/kb/" + name,
+		EmbeddingsModel:    rag.EmbeddingsModel,
+		SystemInstructions: rag.SystemInstructions,
+		Index:              rag.Index,
+	}
+}
+
+// Validate checks the name, the index and the key prefix of the knowledge base.
+func (kb KnowledgeBase) Validate() error {
+	if !knowledgeBaseName.MatchString(kb.Name) {
+		return fmt.Errorf("invalid knowledge base name %q (use lowercase letters, digits, - and _)", kb.Name)
+	}
+	if kb.IndexName == "" || kb.KeyPrefix == "" {
+		return fmt.Errorf("the index name and the key prefix of %s are required", kb.Name)
+	}
+	if kb.EmbeddingsModel == "" {
+		return fmt.Errorf("the embedding model of %s is required", kb.Name)
+	}
+	return kb.Index.Validate()
+}
+
+// Overlaps reports whether two knowledge bases would share their index or their chunks
+// (an index indexes all the keys starting with its prefix).
+// An empty index name (e.g. the embedding cache has no index) doesn't overlap.
+func (kb KnowledgeBase) Overlaps(other KnowledgeBase) bool {
+	return (kb.IndexName != "" && kb.IndexName == other.IndexName) ||
+		strings.HasPrefix(kb.KeyPrefix, other.KeyPrefix) ||
+		strings.HasPrefix(other.KeyPrefix, kb.KeyPrefix)
+}
+
+// ReservedKeySpaces returns the index and the key prefixes that no knowledge base can use:
+// the default index (RAG_INDEX_NAME and RAG_KEY_PREFIX), the embedding cache and the keys of the RAG.
+// Deleting a knowledge base deletes all the keys of its prefix, so they must not overlap.
+func ReservedKeySpaces(defaultIndexName, defaultKeyPrefix string) []KnowledgeBase {
+	return []KnowledgeBase{
+		{Name: "the default index", IndexName: defaultIndexName, KeyPrefix: defaultKeyPrefix},
+		{Name: "the embedding cache", KeyPrefix: embeddingCachePrefix},
+		{Name: "the RAG metadata", KeyPrefix: metadataPrefix},
+	}
+}
+
+// SaveKnowledgeBase stores the definition of a new knowledge base.
+// It fails if the name is taken, or if the index or the key prefix is used by another knowledge base
+// or by a reserved key space (see ReservedKeySpaces).
+func SaveKnowledgeBase(ctx context.Context, rdb *redis.Client, kb KnowledgeBase, reserved []KnowledgeBase) error {
+	if err := kb.Validate(); err != nil {
+		return err
+	}
+	for _, other := range reserved {
+		if kb.Overlaps(other) {
+			return fmt.Errorf("the index or the key prefix of %s overlaps with %s (%s, %s)", kb.Name, other.Name, other.IndexName, other.KeyPrefix)
+		}
+	}
+	knowledgeBases, err := ListKnowledgeBases(ctx, rdb)
+	if err != nil {
+		return err
+	}
+	for _, other := range knowledgeBases {
+		if other.Name == kb.Name {
+			return fmt.Errorf("the knowledge base %s already exists", kb.Name)
+		}
+		if kb.Overlaps(other) {
+			return fmt.Errorf("the index or the key prefix of %s overlaps with %s (%s, %s)", kb.Name, other.Name, other.IndexName, other.KeyPrefix)
+		}
+	}
+	data, err := json.Marshal(kb)
+	if err != nil {
+		return err
+	}
+	return rdb.HSet(ctx, knowledgeBasesKey, kb.Name, data).Err()
+}
+
+// GetKnowledgeBase reads the definition of a knowledge base.
+func GetKnowledgeBase(ctx context.Context, rdb *redis.Client, name string) (KnowledgeBase, error) {
+	var kb KnowledgeBase
+	data, err := rdb.HGet(ctx, knowledgeBasesKey, name).Bytes()
+	if err == redis.Nil {
+		return kb, fmt.Errorf("unknown knowledge base %s", name)
+	}
+	if err != nil {
+		return kb, err
+	}
+	err = json.Unmarshal(data, &kb)
+	return kb, err
+}
+
+// ListKnowledgeBases returns the knowledge bases, sorted by name.
+func ListKnowledgeBases(ctx context.Context, rdb *redis.Client) ([]KnowledgeBase, error) {
+	definitions, err := rdb.HGetAll(ctx, knowledgeBasesKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	knowledgeBases := []KnowledgeBase{}
+	for name, data := range definitions {
+		var kb KnowledgeBase
+		if err := json.Unmarshal([]byte(data), &kb); err != nil {
+			return nil, fmt.Errorf("knowledge base %s: %w", name, err)
+		}
+		knowledgeBases = append(knowledgeBases, kb)
+	}
+	sort.Slice(knowledgeBases, func(i, j int) bool {
+		return knowledgeBases[i].Name < knowledgeBases[j].Name
+	})
+	return knowledgeBases, nil
+}
+
+// DeleteKnowledgeBase drops the index of a knowledge base with its chunks, and deletes its definition.
+func DeleteKnowledgeBase(ctx context.Context, rdb *redis.Client, name string) error {
+	kb, err := GetKnowledgeBase(ctx, rdb, name)
+	if err != nil {
+		return err
+	}
+	// the index may not exist yet (nothing was ingested), so the error is ignored
+	rdb.FTDropIndexWithArgs(ctx, kb.IndexName, &redis.FTDropIndexOptions{DeleteDocs: true})
+	return rdb.HDel(ctx, knowledgeBasesKey, kb.Name).Err()
+}
+
+// UseKnowledgeBase makes the RAG index, search and answer with the settings of the knowledge base.
+func (r *RAG) UseKnowledgeBase(kb KnowledgeBase) {
+	r.IndexName = kb.IndexName
+	r.KeyPrefix = kb.KeyPrefix
+	r.EmbeddingsModel = kb.EmbeddingsModel
+	r.SystemInstructions = kb.SystemInstructions
+	r.Index = kb.Index
+}
+
+// ForKnowledgeBase returns a copy of the RAG using the knowledge base (the clients are shared),
+// so a query can target a knowledge base without changing the RAG.
+func (r *RAG) ForKnowledgeBase(ctx context.Context, name string) (*RAG, error) {
+	kb, err := GetKnowledgeBase(ctx, r.Redis, name)
+	if err != nil {
+		return nil, err
+	}
+	rag := *r
+	rag.UseKnowledgeBase(kb)
+	return &rag, nil
+}
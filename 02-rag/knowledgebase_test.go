@@ -0,0 +1,62 @@
+package main
+
+import "testing"
+
+func TestKnowledgeBaseOverlaps(t *testing.T) {
+	waikiki := KnowledgeBase{Name: "waikiki", IndexName: "waikiki_idx", KeyPrefix: "kb:waikiki:"}
+
+	tests := []struct {
+		name  string
+		other KnowledgeBase
+		want  bool
+	}{
+		{name: "distinct", other: KnowledgeBase{Name: "paris", IndexName: "paris_idx", KeyPrefix: "kb:paris:"}, want: false},
+		{name: "same index", other: KnowledgeBase{Name: "paris", IndexName: "waikiki_idx", KeyPrefix: "kb:paris:"}, want: true},
+		{name: "same prefix", other: KnowledgeBase{Name: "paris", IndexName: "paris_idx", KeyPrefix: "kb:waikiki:"}, want: true},
+		{name: "shorter prefix", other: KnowledgeBase{Name: "kb", IndexName: "kb_idx", KeyPrefix: "kb:"}, want: true},
+		{name: "longer prefix", other: KnowledgeBase{Name: "beach", IndexName: "beach_idx", KeyPrefix: "kb:waikiki:beach:"}, want: true},
+		{name: "prefix sharing the beginning only", other: KnowledgeBase{Name: "wai", IndexName: "wai_idx", KeyPrefix: "kb:wai:"}, want: false},
+		{name: "no index", other: KnowledgeBase{Name: "cache", KeyPrefix: "embcache:"}, want: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := waikiki.Overlaps(test.other); got != test.want {
+				t.Errorf("waikiki.Overlaps(%+v) = %v, expected %v", test.other, got, test.want)
+			}
+			if got := test.other.Overlaps(waikiki); got != test.want {
+				t.Errorf("%+v.Overlaps(waikiki) = %v, expected %v", test.other, got, test.want)
+			}
+		})
+	}
+}
+
+func TestReservedKeySpaces(t *testing.T) {
+	reserved := ReservedKeySpaces("vector_idx", "doc:")
+
+	tests := []struct {
+		name     string
+		kb       KnowledgeBase
+		reserved bool
+	}{
+		{name: "default settings of a knowledge base", kb: KnowledgeBase{IndexName: "paris_idx", KeyPrefix: "kb:paris:"}},
+		{name: "default index", kb: KnowledgeBase{IndexName: "vector_idx", KeyPrefix: "kb:paris:"}, reserved: true},
+		{name: "default prefix", kb: KnowledgeBase{IndexName: "paris_idx", KeyPrefix: "doc:"}, reserved: true},
+		{name: "prefix of the default prefix", kb: KnowledgeBase{IndexName: "paris_idx", KeyPrefix: "d"}, reserved: true},
+		{name: "inside the default prefix", kb: KnowledgeBase{IndexName: "paris_idx", KeyPrefix: "doc:paris:"}, reserved: true},
+		{name: "embedding cache", kb: KnowledgeBase{IndexName: "paris_idx", KeyPrefix: "embcache:paris:"}, reserved: true},
+		{name: "keys of the RAG", kb: KnowledgeBase{IndexName: "paris_idx", KeyPrefix: "rag:"}, reserved: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			overlaps := false
+			for _, other := range reserved {
+				overlaps = overlaps || test.kb.Overlaps(other)
+			}
+			if overlaps != test.reserved {
+				t.Errorf("%+v overlaps with a reserved key space: %v, expected %v", test.kb, overlaps, test.reserved)
+			}
+		})
+	}
+}
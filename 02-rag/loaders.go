@@ -0,0 +1,453 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Section is a piece of text extracted from a document by a loader,
+// with metadata describing where it comes from.
+type Section struct {
+	Source   string
+	ID       string // identifies the section in the source document (empty for the whole document)
+	Text     string
+	Metadata map[string]string
+}
+
+// Loader converts the content of a file into text sections.
+type Loader func(path string, data []byte) ([]Section, error)
+
+// loaders is the registry of the loaders, keyed by file extension.
+var loaders = map[string]Loader{
+	".md":   LoadText,
+	".txt":  LoadText,
+	".html": LoadHTML,
+	".htm":  LoadHTML,
+	".csv":  LoadCSV,
+	".json": LoadJSON,
+}
+
+// RegisterLoader adds (or replaces) the loader of a file extension.
+func RegisterLoader(ext string, loader Loader) {
+	loaders[strings.ToLower(ext)] = loader
+}
+
+// GetLoader returns the loader of a file, based on its extension.
+func GetLoader(path string) (Loader, bool) {
+	loader, ok := loaders[strings.ToLower(filepath.Ext(path))]
+	return loader, ok
+}
+
+// LoadDocuments walks a directory and its subdirectories,
+// and converts every file with a registered loader into text sections.
+//
+// Parameters:
+// - dirPath: The root directory to start the search from.
+//
+// Returns:
+// - []Section: The sections of all the supported files.
+// - error: An error if the walk or a loader fails.
+func LoadDocuments(dirPath string) ([]Section, error) {
+	sections := []Section{}
+	err := filepath.Walk(dirPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		fileSections, err := LoadFile(dirPath, path)
+		if err != nil {
+			return err
+		}
+		sections = append(sections, fileSections...)
+		return nil
+	})
+	return sections, err
+}
+
+// LoadFile converts a file into text sections with the loader registered for its extension.
+// The names of the directories between the root directory and the file are added to the tags of the sections
+// (e.g. /docs/faq/fr/questions.md is tagged with faq and fr).
+// It returns no section (and no error) when there is no loader for the file.
+func LoadFile(rootDir string, path string) ([]Section, error) {
+	loader, ok := GetLoader(path)
+	if !ok {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	sections, err := loader(path, data)
+	if err != nil {
+		return nil, fmt.Errorf("loading %s: %w", path, err)
+	}
+
+	directoryTags := []string{}
+	if relativePath, err := filepath.Rel(rootDir, filepath.Dir(path)); err == nil && relativePath != "." {
+		directoryTags = strings.Split(filepath.ToSlash(relativePath), "/")
+	}
+	for idx := range sections {
+		if sections[idx].Metadata == nil {
+			sections[idx].Metadata = map[string]string{}
+		}
+		AddTags(sections[idx].Metadata, directoryTags...)
+	}
+	return sections, nil
+}
+
+// AddTags adds tags to the comma-separated "tags" entry of the metadata (without duplicates).
+func AddTags(metadata map[string]string, tags ...string) {
+	allTags := SplitTags(metadata["tags"])
+	for _, tag := range tags {
+		tag = NormalizeTag(tag)
+		if tag != "" && !slices.Contains(allTags, tag) {
+			allTags = append(allTags, tag)
+		}
+	}
+	if len(allTags) > 0 {
+		metadata["tags"] = strings.Join(allTags, ",")
+	}
+}
+
+// SplitTags splits a comma-separated list of tags.
+func SplitTags(tags string) []string {
+	result := []string{}
+	for _, tag := range strings.Split(tags, ",") {
+		if tag = NormalizeTag(tag); tag != "" {
+			result = append(result, tag)
+		}
+	}
+	return result
+}
+
+// LoadText loads a plain text (or markdown) file as a single section.
+// The front matter (if any) is removed from the text,
+// and its tags and lang entries are added to the metadata:
+//
+//	---
+//	tags: [faq, history]
+//	lang: en
+//	---
+func LoadText(path string, data []byte) ([]Section, error) {
+	frontMatter, text := ParseFrontMatter(string(data))
+
+	metadata := map[string]string{"format": formatOf(path)}
+	for _, key := range []string{"tags", "tag", "categories"} {
+		AddTags(metadata, frontMatter[key]...)
+	}
+	for _, key := range []string{"lang", "language"} {
+		if len(frontMatter[key]) > 0 {
+			metadata["lang"] = NormalizeTag(frontMatter[key][0])
+		}
+	}
+	if len(frontMatter["title"]) > 0 {
+		metadata["title"] = frontMatter["title"][0]
+	}
+
+	return []Section{{
+		Source:   path,
+		Text:     text,
+		Metadata: metadata,
+	}}, nil
+}
+
+// ParseFrontMatter extracts the (YAML-like) front matter at the beginning of a document.
+// Only the simple forms are supported: "key: value", "key: [a, b]", "key: a, b"
+// and the lists of "- item" lines.
+// It returns the entries of the front matter, and the text without the front matter.
+func ParseFrontMatter(text string) (map[string][]string, string) {
+	entries := map[string][]string{}
+	if !strings.HasPrefix(text, "---\n") && !strings.HasPrefix(text, "---\r\n") {
+		return entries, text
+	}
+	lines := strings.SplitAfter(text, "\n")
+	end := -1
+	for idx := 1; idx < len(lines); idx++ {
+		if strings.TrimSpace(lines[idx]) == "---" {
+			end = idx
+			break
+		}
+	}
+	if end < 0 {
+		return entries, text
+	}
+
+	key := ""
+	for _, line := range lines[1:end] {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if item, isItem := strings.CutPrefix(trimmed, "- "); isItem && key != "" {
+			entries[key] = append(entries[key], unquote(item))
+			continue
+		}
+		name, value, found := strings.Cut(trimmed, ":")
+		if !found {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(name))
+		value = strings.Trim(strings.TrimSpace(value), "[]")
+		for _, item := range strings.Split(value, ",") {
+			if item = unquote(item); item != "" {
+				entries[key] = append(entries[key], item)
+			}
+		}
+	}
+	return entries, strings.Join(lines[end+1:], "")
+}
+
+func unquote(value string) string {
+	return strings.Trim(strings.TrimSpace(value), `"'`)
+}
+
+// LoadHTML strips an HTML file to readable text.
+// The headings are preserved as markdown headings, and the scripts and styles are removed.
+func LoadHTML(path string, data []byte) ([]Section, error) {
+	root, err := html.Parse(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	var text strings.Builder
+	title := ""
+	lang := ""
+	var walk func(node *html.Node)
+	walk = func(node *html.Node) {
+		if node.Type == html.ElementNode {
+			switch node.Data {
+			case "html":
+				for _, attribute := range node.Attr {
+					if attribute.Key == "lang" {
+						lang = NormalizeTag(attribute.Val)
+					}
+				}
+			case "head":
+				title = findTitle(node)
+				return
+			case "script", "style", "noscript":
+				return
+			case "h1", "h2", "h3", "h4", "h5", "h6":
+				level := int(node.Data[1] - '0')
+				text.WriteString("\n\n" + strings.Repeat("#", level) + " " + collapseSpaces(textContent(node)) + "\n\n")
+				return
+			case "li":
+				text.WriteString("\n- ")
+			case "br":
+				text.WriteString("\n")
+			case "p", "div", "section", "article", "ul", "ol", "table", "tr", "blockquote", "pre":
+				text.WriteString("\n")
+			case "td", "th":
+				text.WriteString(" | ")
+			}
+		}
+		if node.Type == html.TextNode {
+			text.WriteString(collapseSpaces(node.Data))
+		}
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+		if node.Type == html.ElementNode {
+			switch node.Data {
+			case "p", "div", "section", "article", "ul", "ol", "table", "tr", "blockquote", "pre":
+				text.WriteString("\n")
+			}
+		}
+	}
+	walk(root)
+
+	metadata := map[string]string{"format": "html"}
+	if title != "" {
+		metadata["title"] = title
+	}
+	if lang != "" {
+		metadata["lang"] = lang
+	}
+	return []Section{{
+		Source:   path,
+		Text:     cleanLines(text.String()),
+		Metadata: metadata,
+	}}, nil
+}
+
+// LoadCSV renders each row of a CSV file (with a header row) as a record:
+//
+//	name: Hawaiian
+//	price: 12.50
+func LoadCSV(path string, data []byte) ([]Section, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) < 2 {
+		return nil, nil
+	}
+
+	header := rows[0]
+	sections := []Section{}
+	for idx, row := range rows[1:] {
+		var record strings.Builder
+		for col, value := range row {
+			name := fmt.Sprintf("column_%d", col+1)
+			if col < len(header) && strings.TrimSpace(header[col]) != "" {
+				name = strings.TrimSpace(header[col])
+			}
+			record.WriteString(name + ": " + strings.TrimSpace(value) + "\n")
+		}
+		sections = append(sections, Section{
+			Source: path,
+			ID:     fmt.Sprintf("row-%d", idx+1),
+			Text:   record.String(),
+			Metadata: map[string]string{
+				"format": "csv",
+				"row":    fmt.Sprint(idx + 1),
+			},
+		})
+	}
+	return sections, nil
+}
+
+// LoadJSON flattens a JSON file by path:
+//
+//	products[0].name: Hawaiian
+//	products[0].toppings[1]: pineapple
+//
+// When the root of the document is an array, every item is a section.
+func LoadJSON(path string, data []byte) ([]Section, error) {
+	var root any
+	if err := json.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+
+	items, isArray := root.([]any)
+	if !isArray {
+		items = []any{root}
+	}
+
+	sections := []Section{}
+	for idx, item := range items {
+		lines := []string{}
+		prefix := ""
+		if isArray {
+			prefix = fmt.Sprintf("[%d]", idx)
+		}
+		flattenJSON(prefix, item, &lines)
+
+		section := Section{
+			Source:   path,
+			Text:     strings.Join(lines, "\n") + "\n",
+			Metadata: map[string]string{"format": "json"},
+		}
+		if isArray {
+			section.ID = prefix
+			section.Metadata["path"] = prefix
+		}
+		sections = append(sections, section)
+	}
+	return sections, nil
+}
+
+func flattenJSON(path string, value any, lines *[]string) {
+	switch value := value.(type) {
+	case map[string]any:
+		// sort the keys to get a stable output
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			childPath := key
+			if path != "" {
+				childPath = path + "." + key
+			}
+			flattenJSON(childPath, value[key], lines)
+		}
+	case []any:
+		for idx, item := range value {
+			flattenJSON(fmt.Sprintf("%s[%d]", path, idx), item, lines)
+		}
+	case nil:
+		*lines = append(*lines, path+": null")
+	default:
+		*lines = append(*lines, fmt.Sprintf("%s: %v", path, value))
+	}
+}
+
+func formatOf(path string) string {
+	return strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+}
+
+func findTitle(node *html.Node) string {
+	if node.Type == html.ElementNode && node.Data == "title" {
+		return collapseSpaces(textContent(node))
+	}
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		if title := findTitle(child); title != "" {
+			return title
+		}
+	}
+	return ""
+}
+
+func textContent(node *html.Node) string {
+	if node.Type == html.TextNode {
+		return node.Data
+	}
+	var text strings.Builder
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		text.WriteString(textContent(child))
+	}
+	return text.String()
+}
+
+// collapseSpaces replaces the runs of white spaces by a single space.
+func collapseSpaces(text string) string {
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		if text != "" {
+			return " "
+		}
+		return ""
+	}
+	collapsed := strings.Join(fields, " ")
+	if strings.TrimLeft(text, " \t\r\n") != text {
+		collapsed = " " + collapsed
+	}
+	if strings.TrimRight(text, " \t\r\n") != text {
+		collapsed += " "
+	}
+	return collapsed
+}
+
+// cleanLines trims the lines and removes the repeated empty lines.
+func cleanLines(text string) string {
+	lines := []string{}
+	empty := true
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			if !empty {
+				lines = append(lines, "")
+			}
+			empty = true
+			continue
+		}
+		lines = append(lines, line)
+		empty = false
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n")) + "\n"
+}
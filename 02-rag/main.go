@@ -1,83 +1,230 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"encoding/binary"
+	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
 	"math"
+	"net/http"
 	"os"
-	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/openai/openai-go"
 	"github.com/openai/openai-go/option"
-
-	"github.com/redis/go-redis/v9"
 )
 
-// MODEL_RUNNER_BASE_URL=http://localhost:12434 go run main.go
+const systemInstructions = `
+	You are a Hawaiian pizza expert. Your name is Bob.
+	Provide accurate, enthusiastic information about Hawaiian pizza, 
+	Use a friendly tone with occasional pizza puns. 
+	Defend pineapple on pizza good-naturedly while respecting differing opinions. 
+	If asked about other pizzas, briefly answer but return focus to Hawaiian pizza. 
+	Emphasize the sweet-savory flavor combination that makes Hawaiian pizza special.
+	USE ONLY THE INFORMATION PROVIDED IN THE KNOWLEDGE BASE.	
+	`
+
+// MODEL_RUNNER_BASE_URL=http://localhost:12434 go run .
+// MODEL_RUNNER_BASE_URL=http://localhost:12434 go run . serve --watch
+// MODEL_RUNNER_BASE_URL=http://localhost:12434 go run . ask --filter faq "Is Hawaiian pizza really from Hawaii?"
+// MODEL_RUNNER_BASE_URL=http://localhost:12434 go run . chat
 func main() {
+	ctx := context.Background()
+
+	rag := NewRAG()
+
+	//? the global flags (before the command) override the environment variables
+	flags := flag.NewFlagSet("quick-rag", flag.ExitOnError)
+	rag.RedisConfig.RegisterFlags(flags)
+	flags.StringVar(&rag.IndexName, "index", rag.IndexName, "name of the vector index (RAG_INDEX_NAME)")
+	flags.StringVar(&rag.KeyPrefix, "prefix", rag.KeyPrefix, "key prefix of the chunks (RAG_KEY_PREFIX)")
+	docsDir := flags.String("docs", GetEnv("RAG_DOCS_DIR", "/docs"), "docs directory (RAG_DOCS_DIR)")
+	flags.StringVar(&rag.HyDE, "hyde", rag.HyDE, "query with a hypothetical answer: off, answer or average (RAG_HYDE)")
+	kbName := flags.String("kb", GetEnv("RAG_KNOWLEDGE_BASE", ""), "name of the knowledge base (RAG_KNOWLEDGE_BASE), see the kb command")
+	flags.Parse(os.Args[1:])
+	//? the knowledge bases can't use the default index and key prefix (nor the keys of the cache and of the RAG)
+	reserved := ReservedKeySpaces(rag.IndexName, rag.KeyPrefix)
+
+	//? a knowledge base brings its own index, key prefix, docs directory, embedding model and persona
+	if *kbName != "" {
+		if err := rag.Connect(ctx); err != nil {
+			log.Fatalln("😡", err)
+		}
+		kb, err := GetKnowledgeBase(ctx, rag.Redis, *kbName)
+		if err != nil {
+			log.Fatalln("😡", err)
+		}
+		rag.UseKnowledgeBase(kb)
+		*docsDir = kb.DocsDir
+		log.Println("📚 Knowledge base:", kb.Name)
+	}
+
+	if err := rag.Index.Validate(); err != nil {
+		log.Fatalln("😡 Invalid index settings:", err)
+	}
+	if err := rag.ValidateChunking(); err != nil {
+		log.Fatalln("😡 Invalid chunking settings:", err)
+	}
+	if err := ValidateHyDE(rag.HyDE); err != nil {
+		log.Fatalln("😡", err)
+	}
+
+	command, args := "demo", []string{}
+	if flags.NArg() > 0 {
+		command, args = flags.Arg(0), flags.Args()[1:]
+	}
+
+	switch command {
+	case "demo":
+		runDemo(ctx, rag, *docsDir)
+	case "ingest":
+		runIngest(ctx, rag, *docsDir, args)
+	case "serve":
+		runServe(ctx, rag, *docsDir, args)
+	case "ask":
+		runAsk(ctx, rag, args)
+	case "benchmark":
+		runBenchmark(ctx, rag, args)
+	case "cache":
+		runCache(ctx, rag, args)
+	case "chat":
+		runChat(ctx, rag, args)
+	case "export":
+		runExport(ctx, rag, args)
+	case "import":
+		runImport(ctx, rag, args)
+	case "kb":
+		runKnowledgeBase(ctx, rag, reserved, args)
+	case "eval":
+		runEval(ctx, rag, args)
+	default:
+		log.Fatalln("😡 Unknown command:", command, "(use demo, ingest, serve, ask, chat, benchmark, cache, export, import, kb or eval)")
+	}
+}
+
+// NewRAG creates the LLM client and the RAG settings from the environment variables.
+func NewRAG() *RAG {
 	// Docker Model Runner Chat base URL
 	llmURL := os.Getenv("MODEL_RUNNER_BASE_URL") + "/engines/llama.cpp/v1/"
-	chatModel := os.Getenv("MODEL_RUNNER_LLM_CHAT")
-	embeddingsModel := os.Getenv("MODEL_RUNNER_LLM_EMBEDDINGS")
 
 	client := openai.NewClient(
 		option.WithBaseURL(llmURL),
 		option.WithAPIKey(""),
 	)
 
-	ctx := context.Background()
+	return &RAG{
+		Client:             client,
+		ChatModel:          os.Getenv("MODEL_RUNNER_LLM_CHAT"),
+		EmbeddingsModel:    os.Getenv("MODEL_RUNNER_LLM_EMBEDDINGS"),
+		RedisConfig:        RedisConfigFromEnv(),
+		IndexName:          GetEnv("RAG_INDEX_NAME", "vector_idx"),
+		KeyPrefix:          GetEnv("RAG_KEY_PREFIX", "doc:"),
+		Index:              IndexSettingsFromEnv(),
+		UseCache:           GetEnv("RAG_EMBEDDING_CACHE", "true") == "true",
+		Ingest:             IngestPolicyFromEnv(),
+		Dedup:              DedupPolicyFromEnv(),
+		ChunkSize:          GetEnvInt("RAG_CHUNK_SIZE", 512),
+		ChunkOverlap:       GetEnvInt("RAG_CHUNK_OVERLAP", 210),
+		ParentChunkSize:    GetEnvInt("RAG_PARENT_CHUNK_SIZE", 0),
+		ParentChunkOverlap: GetEnvInt("RAG_PARENT_CHUNK_OVERLAP", 0),
+		TopK:               3,
+		HyDE:               GetEnv("RAG_HYDE", HyDEOff),
+		SystemInstructions: systemInstructions,
+		Budget: ContextBudget{
+			ContextLength:  GetEnvInt("MODEL_RUNNER_LLM_CHAT_CONTEXT_SIZE", 4096),
+			ReservedTokens: GetEnvInt("RAG_RESERVED_ANSWER_TOKENS", 1024),
+		},
+		Grounding: GroundingPolicyFromEnv(),
+	}
+}
 
+// ingest (re)creates the index and indexes all the documents of the docs directory.
+// The failed chunks are written to the report file.
+func ingest(ctx context.Context, rag *RAG, docsDir string, reportPath string) {
 	// -------------------------------------------------
 	// Make chunks from files
 	// -------------------------------------------------
-	contents, _ := GetContentFiles("/docs", ".md")
-	chunks := []string{}
-	for _, content := range contents {
-		chunks = append(chunks, ChunkText(content, 512, 210)...)
+	//? every supported format (md, txt, html, csv, json) is converted to text sections
+	sections, err := LoadDocuments(docsDir)
+	if err != nil {
+		log.Fatalln("😡 Error reading documents:", err)
 	}
 
 	// -------------------------------------------------
 	// Generate embeddings from chunks
 	// -------------------------------------------------
-	rdb, _ := InitializeRedisAndIndex(ctx)
+	if err := rag.InitializeIndex(ctx); err != nil {
+		log.Fatalln("😡 Error initializing Redis:", err)
+	}
 	log.Println("⏳ Creating embeddings from chunks...")
 
-	for idx, chunk := range chunks {
-		//! create the embedding
-		embeddingsResponse, err := client.Embeddings.New(ctx, openai.EmbeddingNewParams{
-			Input: openai.EmbeddingNewParamsInputUnion{
-				OfString: openai.String(chunk),
-			},
-			Model: embeddingsModel,
-		})
+	report, err := rag.IndexSections(ctx, sections)
+	if err != nil {
+		log.Fatalln("😡 Error indexing documents:", err)
+	}
+	log.Println("📚", len(sections), "sections,", report.Indexed, "chunks indexed,", len(report.Failures), "failed")
+	logDuplicates(report)
+	logCacheStats(rag)
 
-		if err != nil {
-			log.Println("😡 Error creating embedding:", err)
-		}
+	//? the failed chunks are skipped, the ingestion fails only when there are too many of them
+	if err := CheckIngestReport(report, reportPath, rag.Ingest); err != nil {
+		log.Fatalln("😡 Ingestion failed:", err)
+	}
+}
 
-		// convert the embedding to a []float32
-		embedding := make([]float32, len(embeddingsResponse.Data[0].Embedding))
-		for i, f := range embeddingsResponse.Data[0].Embedding {
-			embedding[i] = float32(f)
-		}
-		buffer := floatsToBytes(embedding)
-
-		//! store the embedding in Redis
-		_, errIndex := rdb.HSet(ctx,
-			fmt.Sprintf("doc:%v", idx),
-			map[string]any{
-				"content":   chunk,
-				"embedding": buffer,
-			},
-		).Result()
-
-		if errIndex != nil {
-			log.Println("😡 Error storing embedding:", err)
-		}
-		//log.Println("✅ Embedding created for chunk:", chunk, embeddingsResponse.Data[0].Embedding)
+func logCacheStats(rag *RAG) {
+	if rag.Cache != nil {
+		hits, misses := rag.Cache.Stats()
+		log.Println("🗃️  Embedding cache:", hits, "hits,", misses, "misses")
 	}
+}
+
+// logDuplicates logs the number of duplicate chunks merged by the deduplication.
+func logDuplicates(report IngestReport) {
+	if len(report.Duplicates) > 0 {
+		exact := report.ExactDuplicates()
+		log.Printf("🧹 %d duplicate chunks merged (%d exact, %d near)", len(report.Duplicates), exact, len(report.Duplicates)-exact)
+	}
+}
+
+// runIngest (re)creates the index and indexes the documents,
+// or with --resume, retries the failed chunks of a previous report (the index is kept).
+func runIngest(ctx context.Context, rag *RAG, docsDir string, args []string) {
+	flags := flag.NewFlagSet("ingest", flag.ExitOnError)
+	reportPath := flags.String("report", GetEnv("RAG_INGEST_REPORT", "ingest-failures.json"), "file of the failed chunks")
+	resume := flags.Bool("resume", false, "retry the failed chunks of the report instead of re-creating the index")
+	flags.IntVar(&rag.Ingest.Retries, "retries", rag.Ingest.Retries, "number of retries of a failed chunk")
+	flags.Float64Var(&rag.Ingest.MaxFailureRatio, "max-failure-ratio", rag.Ingest.MaxFailureRatio, "maximum ratio of failed chunks")
+	flags.Parse(args)
+
+	if !*resume {
+		ingest(ctx, rag, docsDir, *reportPath)
+		return
+	}
+
+	previous, err := ReadIngestReport(*reportPath)
+	if err != nil {
+		log.Fatalln("😡 Error reading the failure report:", err)
+	}
+	if err := rag.Connect(ctx); err != nil {
+		log.Fatalln("😡", err)
+	}
+	log.Println("⏳ Retrying", len(previous.Failures), "failed chunks...")
+	report := rag.ResumeIngest(ctx, previous)
+	log.Println("📚", report.Indexed, "chunks indexed,", len(report.Failures), "failed")
+	if err := CheckIngestReport(report, *reportPath, rag.Ingest); err != nil {
+		log.Fatalln("😡 Ingestion failed:", err)
+	}
+}
+
+// runDemo indexes the documents, then asks one question to Bob.
+func runDemo(ctx context.Context, rag *RAG, docsDir string) {
+	ingest(ctx, rag, docsDir, GetEnv("RAG_INGEST_REPORT", "ingest-failures.json"))
 
 	// -------------------------------------------------
 	// User question about 🍍🥓 Hawaiian pizza
@@ -86,251 +233,501 @@ func main() {
 	userQuestion := "Is Hawaiian pizza really from Hawaii?"
 	//userQuestion := "Give me regional variations of Hawaiian pizza?"
 
-	systemInstructions := `
-	You are a Hawaiian pizza expert. Your name is Bob.
-	Provide accurate, enthusiastic information about Hawaiian pizza, 
-	Use a friendly tone with occasional pizza puns. 
-	Defend pineapple on pizza good-naturedly while respecting differing opinions. 
-	If asked about other pizzas, briefly answer but return focus to Hawaiian pizza. 
-	Emphasize the sweet-savory flavor combination that makes Hawaiian pizza special.
-	USE ONLY THE INFORMATION PROVIDED IN THE KNOWLEDGE BASE.	
-	`
+	// -------------------------------------------------
+	// Search for similar documents in Redis
+	// -------------------------------------------------
+	//? the user question is converted to an embedding, and compared to the embeddings of the chunks
+	fmt.Println("⏳ Searching for similar documents in Redis...")
+	similarities, err := rag.Search(ctx, userQuestion, nil)
+	if err != nil {
+		log.Fatalln("😡 Error searching similarities:", err)
+	}
+
+	fmt.Println("🎉 Found", len(similarities), "similarities")
+
+	for _, similarity := range similarities {
+		fmt.Println("📝 ID:", similarity.ID, "Distance:", similarity.Distance)
+		fmt.Println("📝 Content:\n", similarity.Content)
+	}
 
 	// -------------------------------------------------
-	// Generate embeddings from user question
+	// Ask the question to the LLM
 	// -------------------------------------------------
+	fmt.Println("--------------------------------------")
+	fmt.Println("⏳ Asking the question to the LLM...")
+	fmt.Println("--------------------------------------")
+
+	//! the chunks are packed into the context window of the chat model
+	if _, err := rag.Answer(ctx, userQuestion, similarities, os.Stdout); err != nil {
+		log.Fatalln("😡:", err)
+	}
+	fmt.Println("\n--------------------------------------")
+	fmt.Println("🤖 Done!")
+}
 
-	fmt.Println("⏳ Creating embeddings from user question...")
+// runAsk asks a question to Bob, using the documents already indexed.
+func runAsk(ctx context.Context, rag *RAG, args []string) {
+	flags := flag.NewFlagSet("ask", flag.ExitOnError)
+	var filterExpressions stringList
+	flags.Var(&filterExpressions, "filter", "filter expression (e.g. faq, lang:fr, source:faq.md), can be repeated")
+	flags.BoolVar(&rag.Grounding.Enabled, "check", rag.Grounding.Enabled, "check that the claims of the answer are supported by the retrieved chunks")
+	flags.Float64Var(&rag.Grounding.Threshold, "threshold", rag.Grounding.Threshold, "minimum ratio of supported claims")
+	flags.BoolVar(&rag.Grounding.Regenerate, "regenerate", rag.Grounding.Regenerate, "regenerate the answer when the groundedness is below the threshold")
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		log.Fatalln("😡 Usage: ask [--filter expression]... [--check [--threshold ratio] [--regenerate]] question")
+	}
+	question := strings.Join(flags.Args(), " ")
 
-	embeddingsFromUserQuestion, err := client.Embeddings.New(ctx, openai.EmbeddingNewParams{
-		Input: openai.EmbeddingNewParamsInputUnion{
-			OfString: openai.String(userQuestion),
-		},
-		Model: embeddingsModel,
-	})
+	filters, err := ParseFilters(filterExpressions)
+	if err != nil {
+		log.Fatalln("😡 Invalid filter:", err)
+	}
+
+	if err := rag.Connect(ctx); err != nil {
+		log.Fatalln("😡", err)
+	}
+	if !rag.Grounding.Enabled {
+		if _, err := rag.Ask(ctx, question, filters, os.Stdout); err != nil {
+			log.Fatalln("😡:", err)
+		}
+		fmt.Println()
+		return
+	}
 
+	_, report, err := rag.AskGrounded(ctx, question, filters, os.Stdout)
+	fmt.Println()
 	if err != nil {
-		log.Fatalln("😡 Error creating embedding:", err)
+		log.Fatalln("😡:", err)
 	}
+	fmt.Println()
+	fmt.Println(GroundednessSummary(report, rag.Grounding.Threshold))
+}
 
-	fmt.Println("✋ embeddings from the user question:\n", embeddingsFromUserQuestion.Data[0].Embedding)
+// runChat starts an interactive conversation with Bob, using the documents already indexed.
+// Type /bye to quit.
+func runChat(ctx context.Context, rag *RAG, args []string) {
+	flags := flag.NewFlagSet("chat", flag.ExitOnError)
+	var filterExpressions stringList
+	flags.Var(&filterExpressions, "filter", "filter expression (e.g. faq, lang:fr, source:faq.md), can be repeated")
+	maxHistory := flags.Int("history", 10, "maximum number of messages kept in the history")
+	flags.Parse(args)
 
-	// convert the embedding to a []float32
-	embedding := make([]float32, len(embeddingsFromUserQuestion.Data[0].Embedding))
-	for i, f := range embeddingsFromUserQuestion.Data[0].Embedding {
-		embedding[i] = float32(f)
+	filters, err := ParseFilters(filterExpressions)
+	if err != nil {
+		log.Fatalln("😡 Invalid filter:", err)
 	}
 
-	buffer := floatsToBytes(embedding)
+	if err := rag.Connect(ctx); err != nil {
+		log.Fatalln("😡", err)
+	}
+	conversation := NewConversation(rag, filters)
+	conversation.MaxHistory = *maxHistory
+
+	reader := bufio.NewScanner(os.Stdin)
+	for {
+		fmt.Print("🙂 > ")
+		if !reader.Scan() {
+			break
+		}
+		question := strings.TrimSpace(reader.Text())
+		if question == "" {
+			continue
+		}
+		if question == "/bye" {
+			break
+		}
 
-	// Search for similar documents in Redis
-	fmt.Println("⏳ Searching for similar documents in Redis...")
-	results, err := rdb.FTSearchWithArgs(ctx,
-		"vector_idx",
-		"*=>[KNN 3 @embedding $vec AS vector_distance]",
-		&redis.FTSearchOptions{
-			Return: []redis.FTSearchReturn{
-				{FieldName: "vector_distance"},
-				{FieldName: "content"},
-			},
-			DialectVersion: 2,
-			Params: map[string]any{
-				"vec": buffer,
-			},
-		},
-	).Result()
+		fmt.Print("🍍 Bob: ")
+		query, err := conversation.Ask(ctx, question, os.Stdout)
+		fmt.Println()
+		if err != nil {
+			log.Println("😡:", err)
+			continue
+		}
+		if query != question {
+			log.Println("🔎 searched with:", query)
+		}
+	}
+	fmt.Println("👋 Bye!")
+}
 
+// runBenchmark compares the recall@k and the latency of the KNN search of several index settings
+// against a brute-force search, over the chunks already indexed.
+func runBenchmark(ctx context.Context, rag *RAG, args []string) {
+	flags := flag.NewFlagSet("benchmark", flag.ExitOnError)
+	k := flags.Int("k", rag.TopK, "number of neighbors")
+	maxQueries := flags.Int("queries", 50, "number of chunks used as queries")
+	metrics := flags.String("metrics", "COSINE,IP,L2", "distance metrics to evaluate")
+	algorithms := flags.String("algorithms", "FLAT,HNSW", "index algorithms to evaluate")
+	m := flags.Int("m", rag.Index.M, "HNSW M")
+	efConstruction := flags.Int("ef-construction", rag.Index.EFConstruction, "HNSW EF_CONSTRUCTION")
+	efRuntime := flags.Int("ef-runtime", rag.Index.EFRuntime, "HNSW EF_RUNTIME")
+	flags.Parse(args)
+	if *k <= 0 || *maxQueries <= 0 {
+		log.Fatalln("😡 --k and --queries must be positive")
+	}
+
+	if err := rag.Connect(ctx); err != nil {
+		log.Fatalln("😡", err)
+	}
+	vectors, err := LoadStoredVectors(ctx, rag.Redis, rag.KeyPrefix)
 	if err != nil {
-		log.Fatalln("😡 Error searching similarities:", err)
+		log.Fatalln("😡 Error reading the embeddings:", err)
+	}
+	if len(vectors) == 0 {
+		log.Fatalln("😡 No embeddings found, index the documents first")
 	}
 
-	fmt.Println("🎉 Found", len(results.Docs), "similarities")
+	// the stored chunks are used as queries
+	queries := [][]float32{}
+	step := max(1, len(vectors) / *maxQueries)
+	for idx := 0; idx < len(vectors) && len(queries) < *maxQueries; idx += step {
+		queries = append(queries, vectors[idx].Vector)
+	}
 
-	knowledgeBase := ""
+	fmt.Printf("📊 %d chunks, %d queries, recall@%d against brute-force search\n", len(vectors), len(queries), *k)
+	for _, algorithm := range strings.Split(*algorithms, ",") {
+		for _, metric := range strings.Split(*metrics, ",") {
+			settings := rag.Index
+			settings.Algorithm = strings.ToUpper(strings.TrimSpace(algorithm))
+			settings.DistanceMetric = strings.ToUpper(strings.TrimSpace(metric))
+			settings.M, settings.EFConstruction, settings.EFRuntime = *m, *efConstruction, *efRuntime
+			if err := settings.Validate(); err != nil {
+				log.Fatalln("😡", err)
+			}
 
-	for _, doc := range results.Docs {
-		fmt.Println("📝 ID:", doc.ID, "Distance:", doc.Fields["vector_distance"])
-		fmt.Println("📝 Content:\n", doc.Fields["content"])
-		knowledgeBase += doc.Fields["content"]
+			result, err := BenchmarkIndex(ctx, rag.Redis, rag.KeyPrefix, settings, queries, vectors, *k)
+			if err != nil {
+				log.Fatalln("😡 Error benchmarking", settings, err)
+			}
+			fmt.Printf("  %-50s recall: %.3f  latency: %v\n", settings, result.Recall, result.Latency)
+		}
 	}
-	/*
-		The results are ordered according to the value of the vector_distance field,
-		with the lowest distance indicating the greatest similarity to the query.
-	*/
+}
 
-	// -------------------------------------------------
-	// Ask the question to the LLM
-	// -------------------------------------------------
-	fmt.Println("--------------------------------------")
-	fmt.Println("⏳ Asking the question to the LLM...")
-	fmt.Println("--------------------------------------")
+// runCache displays the stats of the embedding cache (cache stats),
+// or deletes the cached embeddings (cache purge [--model name]).
+func runCache(ctx context.Context, rag *RAG, args []string) {
+	if len(args) == 0 {
+		log.Fatalln("😡 Usage: cache stats|purge [--model name]")
+	}
+	rdb, err := ConnectRedis(ctx, rag.RedisConfig)
+	if err != nil {
+		log.Fatalln("😡", err)
+	}
+	cache := NewEmbeddingCache(rdb)
+
+	switch args[0] {
+	case "stats":
+		hits, misses, entries, err := cache.TotalStats(ctx)
+		if err != nil {
+			log.Fatalln("😡 Error reading the cache stats:", err)
+		}
+		hitRatio := 0.0
+		if hits+misses > 0 {
+			hitRatio = float64(hits) / float64(hits+misses)
+		}
+		fmt.Printf("🗃️  %d cached embeddings, %d hits, %d misses (hit ratio: %.1f%%)\n", entries, hits, misses, hitRatio*100)
+	case "purge":
+		flags := flag.NewFlagSet("cache purge", flag.ExitOnError)
+		model := flags.String("model", "", "purge only the embeddings of this model (all the models by default)")
+		flags.Parse(args[1:])
+		deleted, err := cache.Purge(ctx, *model)
+		if err != nil {
+			log.Fatalln("😡 Error purging the cache:", err)
+		}
+		fmt.Println("🗑️ ", deleted, "cached embeddings deleted")
+	default:
+		log.Fatalln("😡 Unknown cache command:", args[0], "(use stats or purge)")
+	}
+}
+
+// runEval compares the retrieval with and without HyDE on a labelled evaluation set (see LoadEvalSet):
+// for every mode, the ratio of questions with a relevant chunk retrieved (hit@k) and the MRR.
+func runEval(ctx context.Context, rag *RAG, args []string) {
+	flags := flag.NewFlagSet("eval", flag.ExitOnError)
+	setPath := flags.String("set", "", "JSON evaluation set (questions labelled with their relevant passages), empty = the embedded eval/relevance.json")
+	modes := flags.String("modes", "off,answer,average", "comma-separated HyDE modes to compare")
+	flags.IntVar(&rag.TopK, "k", rag.TopK, "number of retrieved chunks")
+	var filterExpressions stringList
+	flags.Var(&filterExpressions, "filter", "filter expression restricting the search (e.g. tags:history), can be repeated")
+	flags.Parse(args)
+
+	filters, err := ParseFilters(filterExpressions)
+	if err != nil {
+		log.Fatalln("😡 Invalid filter:", err)
+	}
+	questions, err := LoadEvalSet(*setPath)
+	if err != nil {
+		log.Fatalln("😡 Error reading the evaluation set:", err)
+	}
+	if len(questions) == 0 {
+		log.Fatalln("😡 No question in the evaluation set")
+	}
+	if err := rag.Connect(ctx); err != nil {
+		log.Fatalln("😡", err)
+	}
 
-	messages := []openai.ChatCompletionMessageParamUnion{
-		openai.SystemMessage(systemInstructions),
-		openai.SystemMessage(knowledgeBase),
-		openai.UserMessage(userQuestion),
+	fmt.Printf("📊 %d questions, top %d chunks\n", len(questions), rag.TopK)
+	fmt.Printf("%-8s %8s %8s\n", "HyDE", "hit@k", "MRR")
+	for _, mode := range strings.Split(*modes, ",") {
+		mode = strings.TrimSpace(mode)
+		if err := ValidateHyDE(mode); err != nil {
+			log.Fatalln("😡", err)
+		}
+		result, err := rag.EvaluateRetrieval(ctx, questions, mode, filters)
+		if err != nil {
+			log.Fatalln("😡 Error evaluating", mode+":", err)
+		}
+		fmt.Printf("%-8s %7.1f%% %8.3f\n", result.Mode, result.HitRate()*100, result.MRR)
 	}
+}
 
-	param := openai.ChatCompletionNewParams{
-		Messages:    messages,
-		Model:       chatModel,
-		Temperature: openai.Opt(0.5),
+// runExport writes the index to a portable snapshot directory.
+func runExport(ctx context.Context, rag *RAG, args []string) {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	dirPath := flags.String("out", "./snapshot", "snapshot directory")
+	flags.Parse(args)
+
+	if err := rag.Connect(ctx); err != nil {
+		log.Fatalln("😡", err)
 	}
+	manifest, err := rag.ExportSnapshot(ctx, *dirPath)
+	if err != nil {
+		log.Fatalln("😡 Error exporting the index:", err)
+	}
+	fmt.Println("📦", manifest.Count, "chunks exported to", *dirPath)
+}
 
-	stream := client.Chat.Completions.NewStreaming(ctx, param)
+// runImport re-creates the index from a snapshot directory, without calling the embedding model.
+func runImport(ctx context.Context, rag *RAG, args []string) {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	dirPath := flags.String("in", "./snapshot", "snapshot directory")
+	flags.Parse(args)
 
-	for stream.Next() {
-		chunk := stream.Current()
-		// Stream each chunk as it arrives
-		if len(chunk.Choices) > 0 && chunk.Choices[0].Delta.Content != "" {
-			fmt.Print(chunk.Choices[0].Delta.Content)
+	manifest, err := ReadSnapshotManifest(*dirPath)
+	if err != nil {
+		log.Fatalln("😡 Error reading the snapshot:", err)
+	}
+	if manifest.EmbeddingsModel != rag.EmbeddingsModel {
+		log.Println("✋ The snapshot was created with", manifest.EmbeddingsModel,
+			"but the questions will be embedded with", rag.EmbeddingsModel)
+	}
+
+	manifest, err = rag.ImportSnapshot(ctx, *dirPath)
+	if err != nil {
+		log.Fatalln("😡 Error importing the snapshot:", err)
+	}
+	fmt.Println("📦", manifest.Count, "chunks imported from", *dirPath, "with", manifest.Index)
+}
+
+// runKnowledgeBase manages the knowledge bases:
+//   - kb create name [--index name] [--prefix prefix] [--docs dir] [--embeddings-model model] [--dim n] [--persona text | --persona-file path]
+//   - kb list
+//   - kb delete name (the index and the chunks are deleted)
+//
+// The documents of a knowledge base are indexed with: --kb name ingest
+func runKnowledgeBase(ctx context.Context, rag *RAG, reserved []KnowledgeBase, args []string) {
+	if len(args) == 0 {
+		log.Fatalln("😡 Usage: kb create|list|delete [name]")
+	}
+	if err := rag.Connect(ctx); err != nil {
+		log.Fatalln("😡", err)
+	}
+
+	switch args[0] {
+	case "create":
+		if len(args) < 2 {
+			log.Fatalln("😡 Usage: kb create name [--index name] [--prefix prefix] [--docs dir] [--embeddings-model model] [--dim n] [--persona text | --persona-file path]")
 		}
+		kb := NewKnowledgeBase(args[1], rag)
+		flags := flag.NewFlagSet("kb create", flag.ExitOnError)
+		flags.StringVar(&kb.IndexName, "index", kb.IndexName, "name of the vector index")
+		flags.StringVar(&kb.KeyPrefix, "prefix", kb.KeyPrefix, "key prefix of the chunks")
+		flags.StringVar(&kb.DocsDir, "docs", kb.DocsDir, "docs directory")
+		flags.StringVar(&kb.EmbeddingsModel, "embeddings-model", kb.EmbeddingsModel, "embedding model")
+		flags.IntVar(&kb.Index.Dim, "dim", kb.Index.Dim, "dimension of the vectors of the embedding model")
+		flags.StringVar(&kb.SystemInstructions, "persona", kb.SystemInstructions, "system instructions of the assistant")
+		personaFile := flags.String("persona-file", "", "file of the system instructions of the assistant")
+		flags.Parse(args[2:])
+
+		if *personaFile != "" {
+			data, err := os.ReadFile(*personaFile)
+			if err != nil {
+				log.Fatalln("😡 Error reading the persona:", err)
+			}
+			kb.SystemInstructions = string(data)
+		}
+		if err := SaveKnowledgeBase(ctx, rag.Redis, kb, reserved); err != nil {
+			log.Fatalln("😡 Error creating the knowledge base:", err)
+		}
+		rag.UseKnowledgeBase(kb)
+		if err := rag.InitializeIndex(ctx); err != nil {
+			log.Fatalln("😡 Error creating the index:", err)
+		}
+		fmt.Println("📚 Knowledge base", kb.Name, "created (index:", kb.IndexName+", docs:", kb.DocsDir+")")
+		fmt.Println("   index the documents with: --kb", kb.Name, "ingest")
+	case "list":
+		knowledgeBases, err := ListKnowledgeBases(ctx, rag.Redis)
+		if err != nil {
+			log.Fatalln("😡 Error listing the knowledge bases:", err)
+		}
+		if len(knowledgeBases) == 0 {
+			fmt.Println("📭 No knowledge base")
+		}
+		for _, kb := range knowledgeBases {
+			info, err := rag.Redis.FTInfo(ctx, kb.IndexName).Result()
+			count := "no index"
+			if err == nil {
+				count = fmt.Sprintf("%d chunks", info.NumDocs)
+			}
+			fmt.Printf("📚 %s\tindex: %s\tprefix: %s\tdocs: %s\tmodel: %s\t%s\n",
+				kb.Name, kb.IndexName, kb.KeyPrefix, kb.DocsDir, kb.EmbeddingsModel, count)
+		}
+	case "delete":
+		if len(args) < 2 {
+			log.Fatalln("😡 Usage: kb delete name")
+		}
+		if err := DeleteKnowledgeBase(ctx, rag.Redis, args[1]); err != nil {
+			log.Fatalln("😡 Error deleting the knowledge base:", err)
+		}
+		fmt.Println("🗑️  Knowledge base", args[1], "deleted")
+	default:
+		log.Fatalln("😡 Unknown kb command:", args[0], "(use create, list or delete)")
 	}
+}
+
+// stringList is a flag that can be repeated.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
 
-	if err := stream.Err(); err != nil {
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// runServe indexes the documents, then answers the questions over HTTP.
+// With --watch, the documents are re-indexed as they change, while the server keeps answering.
+func runServe(ctx context.Context, rag *RAG, docsDir string, args []string) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := flags.String("addr", GetEnv("RAG_HTTP_ADDR", ":8080"), "address of the query server")
+	watch := flags.Bool("watch", GetEnv("RAG_WATCH", "false") == "true", "re-index the documents as they change")
+	interval := flags.Duration("interval", 2*time.Second, "delay between two scans of the docs directory")
+	debounce := flags.Duration("debounce", 3*time.Second, "quiet period before re-indexing a burst of changes")
+	flags.Parse(args)
+
+	ingest(ctx, rag, docsDir, GetEnv("RAG_INGEST_REPORT", "ingest-failures.json"))
+
+	if *watch {
+		go func() {
+			log.Println("👀 Watching", docsDir)
+			err := WatchDirectory(ctx, docsDir, *interval, *debounce, func(changed, removed []string) {
+				rag.ReindexChanges(ctx, docsDir, changed, removed)
+			})
+			if err != nil {
+				log.Println("😡 Error watching the documents:", err)
+			}
+		}()
+	}
+
+	log.Println("🚀 Query server listening on", *addr)
+	if err := http.ListenAndServe(*addr, NewServer(rag)); err != nil {
 		log.Fatalln("😡:", err)
 	}
-	fmt.Println("\n--------------------------------------")
-	fmt.Println("🤖 Done!")
 }
 
-// ChunkText takes a text string and divides it into chunks of a specified size with a given overlap.
-// It returns a slice of strings, where each string represents a chunk of the original text.
+// Chunk is a piece of a document, with its position in the source document.
+type Chunk struct {
+	Source   string            `json:"source"`
+	Section  string            `json:"section,omitempty"`
+	Index    int               `json:"index"`
+	Start    int               `json:"start"`
+	Content  string            `json:"content"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Parent   string            `json:"parent,omitempty"`  // the key of the parent chunk (parent-document retrieval)
+	Sources  []string          `json:"sources,omitempty"` // the other sources of the chunk, when duplicates were merged into it
+}
+
+// MakeChunks divides the content of a document into chunks of a specified size with a given overlap,
+// and keeps the source and the start offset of each chunk.
 //
 // Parameters:
-//   - text: The input text to be chunked.
+//   - source: The path of the document.
+//   - text: The content of the document.
 //   - chunkSize: The size of each chunk.
 //   - overlap: The amount of overlap between consecutive chunks.
 //
 // Returns:
-//   - []string: A slice of strings representing the chunks of the original text.
-func ChunkText(text string, chunkSize, overlap int) []string {
-	chunks := []string{}
+//   - []Chunk: A slice of chunks of the document.
+func MakeChunks(source, text string, chunkSize, overlap int) []Chunk {
+	chunks := []Chunk{}
 	for start := 0; start < len(text); start += chunkSize - overlap {
 		end := start + chunkSize
 		if end > len(text) {
 			end = len(text)
 		}
-		chunks = append(chunks, text[start:end])
+		chunks = append(chunks, Chunk{
+			Source:  source,
+			Index:   len(chunks),
+			Start:   start,
+			Content: text[start:end],
+		})
 	}
 	return chunks
 }
 
-// GetContentFiles searches for files with a specific extension in the given directory and its subdirectories.
-//
-// Parameters:
-// - dirPath: The directory path to start the search from.
-// - ext: The file extension to search for.
-//
-// Returns:
-// - []string: A slice of file paths that match the given extension.
-// - error: An error if the search encounters any issues.
-func GetContentFiles(dirPath string, ext string) ([]string, error) {
-	content := []string{}
-	_, err := ForEachFile(dirPath, ext, func(path string) error {
-		data, err := os.ReadFile(path)
-		if err != nil {
-			return err
-		}
+// ChunkSection divides a section of a document into chunks,
+// the chunks keep the metadata of the section.
+func ChunkSection(section Section, chunkSize, overlap int) []Chunk {
+	chunks := MakeChunks(section.Source, section.Text, chunkSize, overlap)
+	for idx := range chunks {
+		chunks[idx].Section = section.ID
+		chunks[idx].Metadata = section.Metadata
+	}
+	return chunks
+}
 
-		content = append(content, string(data))
-		return nil
-	})
+// MetadataToJSON serializes the metadata of a chunk to store it in Redis.
+func MetadataToJSON(metadata map[string]string) string {
+	data, err := json.Marshal(metadata)
 	if err != nil {
-		return nil, err
+		return "{}"
 	}
+	return string(data)
+}
 
-	return content, nil
+// GetEnv returns the value of an environment variable,
+// or the default value if the variable is not set.
+func GetEnv(name string, defaultValue string) string {
+	if value, ok := os.LookupEnv(name); ok && value != "" {
+		return value
+	}
+	return defaultValue
 }
 
-// ForEachFile iterates over all files with a specific extension in a directory and its subdirectories.
-//
-// Parameters:
-// - dirPath: The root directory to start the search from.
-// - ext: The file extension to search for.
-// - callback: A function to be called for each file found.
-//
-// Returns:
-// - []string: A slice of file paths that match the given extension.
-// - error: An error if the search encounters any issues.
-func ForEachFile(dirPath string, ext string, callback func(string) error) ([]string, error) {
-	var textFiles []string
-	err := filepath.Walk(dirPath, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if !info.IsDir() && filepath.Ext(path) == ext {
-			textFiles = append(textFiles, path)
-			err = callback(path)
-			// generate an error to stop the walk
-			if err != nil {
-				return err
-			}
-		}
-		return nil
-	})
-	return textFiles, err
+// GetEnvInt returns the value of an integer environment variable,
+// or the default value if the variable is not set or invalid.
+func GetEnvInt(name string, defaultValue int) int {
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil {
+		return defaultValue
+	}
+	return value
 }
 
-func InitializeRedisAndIndex(ctx context.Context) (*redis.Client, error) {
-	// connect to Redis and delete any index previously created with the name vector_idx:
-	rdb := redis.NewClient(&redis.Options{
-		//Addr:     "redis-server:6379",
-		//Addr:     "0.0.0.0:6379",
-		Addr:     "host.docker.internal:6379",
-		Password: "", // no password docs
-		DB:       0,  // use default DB
-		Protocol: 2,
-	})
-
-	rdb.FTDropIndexWithArgs(ctx,
-		"vector_idx",
-		&redis.FTDropIndexOptions{
-			DeleteDocs: true,
-		},
-	)
-	/*
-		Next, create the index.
-		The schema in the example below specifies hash objects for storage and includes three fields:
-		 - the text content to index,
-		 - a tag field to represent the "genre" of the text,
-		 - and the embedding vector generated from the original text content.
-		The embedding field specifies HNSW indexing, the L2 vector distance metric, Float32 values to represent the vector's components,
-		and 384 dimensions, as required by the all-MiniLM-L6-v2 embedding model.
-	*/
-	_, err := rdb.FTCreate(ctx,
-		"vector_idx",
-		&redis.FTCreateOptions{
-			OnHash: true,
-			Prefix: []any{"doc:"},
-		},
-		&redis.FieldSchema{
-			FieldName: "content",
-			FieldType: redis.SearchFieldTypeText,
-		},
-		//&redis.FieldSchema{
-		//	FieldName: "genre",
-		//	FieldType: redis.SearchFieldTypeTag,
-		//},
-		&redis.FieldSchema{
-			FieldName: "embedding",
-			FieldType: redis.SearchFieldTypeVector,
-			VectorArgs: &redis.FTVectorArgs{
-				HNSWOptions: &redis.FTHNSWOptions{
-					Dim:            1024,
-					DistanceMetric: "L2",
-					Type:           "FLOAT32",
-				},
-			},
-		},
-	).Result()
+func bytesToFloats(buf []byte) []float32 {
+	fs := make([]float32, len(buf)/4)
 
-	if err != nil {
-		log.Println("😡 Error creating index:", err)
-		return nil, err
+	for i := range fs {
+		fs[i] = math.Float32frombits(binary.NativeEndian.Uint32(buf[i*4:]))
 	}
-	return rdb, nil
 
+	return fs
 }
 
 func floatsToBytes(fs []float32) []byte {
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strconv"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Parent-document retrieval:
+// small child chunks embed precisely, so they are used for the vector search,
+// but the larger parent chunk of each matched child is sent to the chat model.
+// The parents are stored without embedding (so the KNN search never returns them),
+// and every child keeps the key of its parent.
+
+// ChunkParents divides a section into parent chunks, then each parent into child chunks.
+// The start offsets of the children are relative to the section, like the ones of the parents.
+//
+// Returns:
+//   - []Chunk: The parent chunks.
+//   - [][]Chunk: The child chunks of each parent.
+func ChunkParents(section Section, parentSize, parentOverlap, childSize, childOverlap int) ([]Chunk, [][]Chunk) {
+	parents := ChunkSection(section, parentSize, parentOverlap)
+	children := make([][]Chunk, len(parents))
+	for idx, parent := range parents {
+		children[idx] = ChunkSection(Section{
+			Source:   section.Source,
+			ID:       section.ID,
+			Text:     parent.Content,
+			Metadata: section.Metadata,
+		}, childSize, childOverlap)
+		for childIdx := range children[idx] {
+			children[idx][childIdx].Start += parent.Start
+		}
+	}
+	return parents, children
+}
+
+// ValidateChunking checks the sizes of the chunks and of the parent chunks.
+func (r *RAG) ValidateChunking() error {
+	if r.ChunkSize <= 0 || r.ChunkOverlap < 0 || r.ChunkOverlap >= r.ChunkSize {
+		return fmt.Errorf("the chunk overlap (%d) must be smaller than the chunk size (%d)", r.ChunkOverlap, r.ChunkSize)
+	}
+	if r.ParentChunkSize <= 0 {
+		return nil
+	}
+	if r.ParentChunkOverlap < 0 || r.ParentChunkOverlap >= r.ParentChunkSize {
+		return fmt.Errorf("the parent chunk overlap (%d) must be smaller than the parent chunk size (%d)", r.ParentChunkOverlap, r.ParentChunkSize)
+	}
+	if r.ChunkSize >= r.ParentChunkSize {
+		return fmt.Errorf("the chunk size (%d) must be smaller than the parent chunk size (%d)", r.ChunkSize, r.ParentChunkSize)
+	}
+	return nil
+}
+
+// ParentKey returns the Redis key of a parent chunk of a source document: <source key prefix>parent:<idx>.
+func (r *RAG) ParentKey(source string, idx int) string {
+	return fmt.Sprintf("%sparent:%d", r.SourceKeyPrefix(source), idx)
+}
+
+// StoreParent stores a parent chunk with its metadata (but without embedding) in Redis.
+func (r *RAG) StoreParent(ctx context.Context, key string, parent Chunk) error {
+	err := r.Redis.HSet(ctx,
+		key,
+		map[string]any{
+			"kind":        "parent",
+			"content":     parent.Content,
+			"source":      parent.Source,
+			"chunk_index": parent.Index,
+			"start":       parent.Start,
+			"section":     parent.Section,
+			"metadata":    MetadataToJSON(parent.Metadata),
+			"tags":        parent.Metadata["tags"],
+			"lang":        parent.Metadata["lang"],
+			"source_name": filepath.Base(parent.Source),
+		},
+	).Err()
+	if err != nil {
+		return fmt.Errorf("storing parent chunk: %w", err)
+	}
+	return nil
+}
+
+// ResolveParents replaces the matched child chunks by their parent chunks.
+// The parents are deduplicated: a parent keeps the best distance of its children, and its rank.
+// The chunks without parent (or whose parent is missing) are kept as they are.
+func (r *RAG) ResolveParents(ctx context.Context, chunks []RetrievedChunk) ([]RetrievedChunk, error) {
+	resolved := []RetrievedChunk{}
+	seen := map[string]bool{}
+	for _, chunk := range chunks {
+		if chunk.Parent == "" {
+			resolved = append(resolved, chunk)
+			continue
+		}
+		if seen[chunk.Parent] {
+			continue
+		}
+		seen[chunk.Parent] = true
+
+		fields, err := r.Redis.HMGet(ctx, chunk.Parent, "content", "source", "section", "start").Result()
+		if err != nil && err != redis.Nil {
+			return nil, err
+		}
+		content, ok := fields[0].(string)
+		if !ok {
+			resolved = append(resolved, chunk) // the parent was deleted, use the child
+			continue
+		}
+		parent := RetrievedChunk{
+			ID:       chunk.Parent,
+			Source:   chunk.Source,
+			Section:  chunk.Section,
+			Start:    -1,
+			Content:  content,
+			Distance: chunk.Distance,
+			Sources:  chunk.Sources,
+		}
+		if source, ok := fields[1].(string); ok {
+			parent.Source = source
+		}
+		if section, ok := fields[2].(string); ok {
+			parent.Section = section
+		}
+		if start, ok := fields[3].(string); ok {
+			if value, err := strconv.Atoi(start); err == nil {
+				parent.Start = value
+			}
+		}
+		resolved = append(resolved, parent)
+	}
+	return resolved, nil
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/openai/openai-go"
+	"github.com/redis/go-redis/v9"
+)
+
+// RAG gathers everything needed to index the documents and to answer the questions:
+// the LLM client, the Redis client and the settings.
+type RAG struct {
+	Client          openai.Client
+	RedisConfig     RedisConfig
+	Redis           *redis.Client
+	ChatModel       string
+	EmbeddingsModel string
+
+	IndexName    string
+	KeyPrefix    string
+	Index        IndexSettings
+	Ingest       IngestPolicy
+	Dedup        DedupPolicy
+	UseCache     bool            // cache the embeddings in Redis
+	Cache        *EmbeddingCache // nil when the embedding cache is disabled
+	ChunkSize    int
+	ChunkOverlap int
+	TopK         int
+	HyDE         string // query with a hypothetical answer: off, answer or average (see QueryEmbedding)
+
+	// parent-document retrieval (see ChunkParents), disabled when ParentChunkSize is 0
+	ParentChunkSize    int
+	ParentChunkOverlap int
+
+	SystemInstructions string
+	Budget             ContextBudget
+	Grounding          GroundingPolicy // verification of the answers (see AskGrounded)
+}
+
+// Connect connects to Redis (see ConnectRedis), unless the RAG is already connected.
+func (r *RAG) Connect(ctx context.Context) error {
+	if r.Redis != nil {
+		return nil
+	}
+	rdb, err := ConnectRedis(ctx, r.RedisConfig)
+	if err != nil {
+		return err
+	}
+	r.UseRedis(rdb)
+	return nil
+}
+
+// InitializeIndex connects to Redis (if needed) and (re)creates the index.
+func (r *RAG) InitializeIndex(ctx context.Context) error {
+	if err := r.Connect(ctx); err != nil {
+		return err
+	}
+	return InitializeIndex(ctx, r.Redis, r.IndexName, r.KeyPrefix, r.Index)
+}
+
+// UseRedis sets the Redis client of the RAG (and of the embedding cache when it is enabled).
+func (r *RAG) UseRedis(rdb *redis.Client) {
+	r.Redis = rdb
+	if r.UseCache {
+		r.Cache = NewEmbeddingCache(rdb)
+	}
+}
+
+// CreateEmbedding converts a text into an embedding vector.
+// The embedding cache is consulted before calling the embedding model.
+func (r *RAG) CreateEmbedding(ctx context.Context, text string) ([]float32, error) {
+	embedding, err := r.createRawEmbedding(ctx, text)
+	if err != nil {
+		return nil, err
+	}
+	if r.Index.Normalize {
+		// don't modify the cached vector
+		embedding = NormalizeVector(append([]float32(nil), embedding...))
+	}
+	return embedding, nil
+}
+
+func (r *RAG) createRawEmbedding(ctx context.Context, text string) ([]float32, error) {
+	if r.Cache != nil {
+		if embedding, ok := r.Cache.Get(ctx, r.EmbeddingsModel, text); ok {
+			return embedding, nil
+		}
+	}
+
+	embeddingsResponse, err := r.Client.Embeddings.New(ctx, openai.EmbeddingNewParams{
+		Input: openai.EmbeddingNewParamsInputUnion{
+			OfString: openai.String(text),
+		},
+		Model: r.EmbeddingsModel,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(embeddingsResponse.Data) == 0 {
+		return nil, fmt.Errorf("no embedding returned by %s", r.EmbeddingsModel)
+	}
+
+	// convert the embedding to a []float32
+	embedding := make([]float32, len(embeddingsResponse.Data[0].Embedding))
+	for i, f := range embeddingsResponse.Data[0].Embedding {
+		embedding[i] = float32(f)
+	}
+
+	if r.Cache != nil {
+		if err := r.Cache.Set(ctx, r.EmbeddingsModel, text, embedding); err != nil {
+			log.Println("😡 Error caching embedding:", err)
+		}
+	}
+	return embedding, nil
+}
+
+// SourceKeyPrefix returns the prefix of the Redis keys of the chunks of a source document,
+// so all the chunks of a document can be found (and deleted) when the document changes.
+func (r *RAG) SourceKeyPrefix(source string) string {
+	hash := sha256.Sum256([]byte(source))
+	return r.KeyPrefix + hex.EncodeToString(hash[:])[:16] + ":"
+}
+
+// IndexSections chunks the sections, creates the embeddings of the chunks and stores them in Redis.
+// With parent-document retrieval, the parent chunks are stored too, and the chunks reference their parent.
+// With deduplication, the duplicates of a chunk are merged into it (see DeduplicateChunks).
+// The previous chunks of the sources of the sections are replaced.
+// A chunk that can't be indexed (after the retries of the ingest policy) is skipped and recorded in the report.
+func (r *RAG) IndexSections(ctx context.Context, sections []Section) (IngestReport, error) {
+	// group the sections by source document
+	sources := []string{}
+	sectionsBySource := map[string][]Section{}
+	for _, section := range sections {
+		if _, ok := sectionsBySource[section.Source]; !ok {
+			sources = append(sources, section.Source)
+		}
+		sectionsBySource[section.Source] = append(sectionsBySource[section.Source], section)
+	}
+
+	report := IngestReport{}
+	pending := []pendingChunk{}
+	keep := map[string]bool{} // the keys of the chunks and of the parents of the new version of the sources
+	for _, source := range sources {
+		chunks := []Chunk{}
+		parentCount := 0
+		for _, section := range sectionsBySource[source] {
+			if r.ParentChunkSize <= 0 {
+				chunks = append(chunks, ChunkSection(section, r.ChunkSize, r.ChunkOverlap)...)
+				continue
+			}
+			parents, children := ChunkParents(section, r.ParentChunkSize, r.ParentChunkOverlap, r.ChunkSize, r.ChunkOverlap)
+			for idx, parent := range parents {
+				key := r.ParentKey(source, parentCount)
+				if err := r.StoreParent(ctx, key, parent); err != nil {
+					return report, err
+				}
+				keep[key] = true
+				parentCount++
+				for _, child := range children[idx] {
+					child.Parent = key
+					chunks = append(chunks, child)
+				}
+			}
+		}
+		for idx, chunk := range chunks {
+			key := fmt.Sprintf("%s%d", r.SourceKeyPrefix(source), idx)
+			pending = append(pending, pendingChunk{Key: key, Chunk: chunk})
+			keep[key] = true
+		}
+	}
+
+	if r.Dedup.Enabled {
+		var err error
+		pending, err = r.DeduplicateChunks(ctx, pending, &report)
+		if err != nil {
+			return report, err
+		}
+		// the merged duplicates are not stored anymore
+		for _, duplicate := range report.Duplicates {
+			delete(keep, duplicate.Key)
+		}
+	}
+
+	// the keys are overwritten in place, so the documents stay searchable while they are re-indexed
+	for _, chunk := range pending {
+		attempts, err := r.withRetry(ctx, chunk.Key, func() error {
+			if chunk.Embedding != nil {
+				return r.StoreChunkEmbedding(ctx, chunk.Key, chunk.Chunk, chunk.Embedding)
+			}
+			return r.StoreChunk(ctx, chunk.Key, chunk.Chunk)
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return report, ctx.Err()
+			}
+			report.Failures = append(report.Failures, ChunkFailure{Key: chunk.Key, Chunk: chunk.Chunk, Error: err.Error(), Attempts: attempts})
+			continue
+		}
+		report.Indexed++
+	}
+
+	// then the chunks left over from a previous version are removed
+	for _, source := range sources {
+		if _, err := r.deleteSourceChunks(ctx, source, keep); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// pendingChunk is a chunk to index with its Redis key,
+// and its embedding when it was already created (by the deduplication).
+type pendingChunk struct {
+	Key       string
+	Chunk     Chunk
+	Embedding []float32
+}
+
+// StoreChunk creates the embedding of a chunk and stores it with its metadata in Redis.
+func (r *RAG) StoreChunk(ctx context.Context, key string, chunk Chunk) error {
+	//! create the embedding
+	embedding, err := r.CreateEmbedding(ctx, chunk.Content)
+	if err != nil {
+		return fmt.Errorf("creating embedding: %w", err)
+	}
+	return r.StoreChunkEmbedding(ctx, key, chunk, embedding)
+}
+
+// StoreChunkEmbedding stores a chunk with its metadata and its embedding in Redis.
+// A chunk merged with duplicates is also found with the names of their sources and with their tags.
+func (r *RAG) StoreChunkEmbedding(ctx context.Context, key string, chunk Chunk, embedding []float32) error {
+	sourceNames := []string{filepath.Base(chunk.Source)}
+	for _, source := range chunk.Sources {
+		sourceNames = append(sourceNames, filepath.Base(source))
+	}
+
+	//! store the embedding in Redis
+	_, err := r.Redis.HSet(ctx,
+		key,
+		map[string]any{
+			"content":     chunk.Content,
+			"source":      chunk.Source,
+			"sources":     strings.Join(chunk.Sources, ","),
+			"chunk_index": chunk.Index,
+			"start":       chunk.Start,
+			"section":     chunk.Section,
+			"metadata":    MetadataToJSON(chunk.Metadata),
+			"tags":        chunk.Metadata["tags"],
+			"lang":        chunk.Metadata["lang"],
+			"source_name": strings.Join(sourceNames, ","),
+			"parent":      chunk.Parent,
+			"embedding":   floatsToBytes(embedding),
+		},
+	).Result()
+	if err != nil {
+		return fmt.Errorf("storing embedding: %w", err)
+	}
+	return nil
+}
+
+// DeleteSource removes all the chunks of a source document from Redis.
+func (r *RAG) DeleteSource(ctx context.Context, source string) (int, error) {
+	return r.deleteSourceChunks(ctx, source, nil)
+}
+
+// deleteSourceChunks removes the chunks (and the parent chunks) of a source document, except the ones to keep.
+func (r *RAG) deleteSourceChunks(ctx context.Context, source string, keep map[string]bool) (int, error) {
+	prefix := r.SourceKeyPrefix(source)
+	deleted := 0
+	iter := r.Redis.Scan(ctx, 0, prefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		if keep[iter.Val()] {
+			continue
+		}
+		if err := r.Redis.Del(ctx, iter.Val()).Err(); err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, iter.Err()
+}
+
+// Search returns the chunks the most similar to the question.
+// The filters are applied before the KNN search (pre-filtering).
+// The chunks that have a parent are replaced by their (deduplicated) parent chunks.
+func (r *RAG) Search(ctx context.Context, question string, filters []Filter) ([]RetrievedChunk, error) {
+	embedding, err := r.QueryEmbedding(ctx, question)
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := r.Redis.FTSearchWithArgs(ctx,
+		r.IndexName,
+		fmt.Sprintf("%s=>[KNN %d @embedding $vec AS vector_distance]", FilterQuery(filters), r.TopK),
+		&redis.FTSearchOptions{
+			Return: []redis.FTSearchReturn{
+				{FieldName: "vector_distance"},
+				{FieldName: "content"},
+				{FieldName: "source"},
+				{FieldName: "section"},
+				{FieldName: "start"},
+				{FieldName: "parent"},
+				{FieldName: "sources"},
+			},
+			DialectVersion: 2,
+			Params: map[string]any{
+				"vec": floatsToBytes(embedding),
+			},
+		},
+	).Result()
+	if err != nil {
+		return nil, err
+	}
+	/*
+		The results are ordered according to the value of the vector_distance field,
+		with the lowest distance indicating the greatest similarity to the query.
+	*/
+	return r.ResolveParents(ctx, DocumentsToChunks(results.Docs))
+}
+
+// Ask searches the knowledge base for the question, and streams the answer of Bob to the writer.
+// It returns the blocks of the knowledge base used to answer.
+func (r *RAG) Ask(ctx context.Context, question string, filters []Filter, out io.Writer) ([]RetrievedChunk, error) {
+	similarities, err := r.Search(ctx, question, filters)
+	if err != nil {
+		return nil, err
+	}
+	return r.Answer(ctx, question, similarities, out)
+}
+
+// Answer streams the answer of Bob to the writer, using the similarities as knowledge base.
+// It returns the blocks of the knowledge base used to answer.
+func (r *RAG) Answer(ctx context.Context, question string, similarities []RetrievedChunk, out io.Writer) ([]RetrievedChunk, error) {
+	return r.AnswerWithHistory(ctx, question, nil, similarities, out)
+}
+
+// AnswerWithHistory streams the answer of Bob to the writer, like Answer,
+// with the previous turns of the conversation between the knowledge base and the question.
+func (r *RAG) AnswerWithHistory(ctx context.Context, question string, history []ChatTurn, similarities []RetrievedChunk, out io.Writer) ([]RetrievedChunk, error) {
+	//! pack the chunks into the context window of the chat model
+	budget := r.Budget
+	budget.PromptTokens = EstimateTokens(r.SystemInstructions) + EstimateTokens(question)
+	for _, turn := range history {
+		budget.PromptTokens += EstimateTokens(turn.Content)
+	}
+	knowledgeBase, blocks := BuildKnowledgeBase(similarities, budget)
+
+	messages := []openai.ChatCompletionMessageParamUnion{
+		openai.SystemMessage(r.SystemInstructions),
+		openai.SystemMessage(knowledgeBase),
+	}
+	messages = append(messages, HistoryMessages(history)...)
+	messages = append(messages, openai.UserMessage(question))
+
+	param := openai.ChatCompletionNewParams{
+		Messages:    messages,
+		Model:       r.ChatModel,
+		Temperature: openai.Opt(0.5),
+	}
+
+	stream := r.Client.Chat.Completions.NewStreaming(ctx, param)
+
+	for stream.Next() {
+		chunk := stream.Current()
+		// Stream each chunk as it arrives
+		if len(chunk.Choices) > 0 && chunk.Choices[0].Delta.Content != "" {
+			if _, err := io.WriteString(out, chunk.Choices[0].Delta.Content); err != nil {
+				return blocks, err
+			}
+			if flusher, ok := out.(interface{ Flush() }); ok {
+				flusher.Flush()
+			}
+		}
+	}
+	return blocks, stream.Err()
+}
+
+// Complete returns the (non-streamed) answer of the chat model to the messages.
+func (r *RAG) Complete(ctx context.Context, messages []openai.ChatCompletionMessageParamUnion, temperature float64) (string, error) {
+	completion, err := r.Client.Chat.Completions.New(ctx, openai.ChatCompletionNewParams{
+		Messages:    messages,
+		Model:       r.ChatModel,
+		Temperature: openai.Opt(temperature),
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(completion.Choices) == 0 {
+		return "", fmt.Errorf("no answer returned by %s", r.ChatModel)
+	}
+	return completion.Choices[0].Message.Content, nil
+}
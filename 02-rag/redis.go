@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisConfig describes the connection to the Redis server.
+type RedisConfig struct {
+	Addr        string
+	Username    string
+	Password    string
+	DB          int
+	TLS         bool
+	TLSInsecure bool   // don't verify the certificate of the server
+	TLSCAFile   string // PEM file of the CA certificates of the server (empty: the CA certificates of the system)
+	Retries     int    // number of connection attempts
+	RetryDelay  time.Duration
+}
+
+// RedisConfigFromEnv reads the Redis configuration from the environment variables.
+func RedisConfigFromEnv() RedisConfig {
+	return RedisConfig{
+		Addr:        GetEnv("REDIS_ADDR", "host.docker.internal:6379"),
+		Username:    GetEnv("REDIS_USERNAME", ""),
+		Password:    GetEnv("REDIS_PASSWORD", ""),
+		DB:          GetEnvInt("REDIS_DB", 0),
+		TLS:         GetEnv("REDIS_TLS", "false") == "true",
+		TLSInsecure: GetEnv("REDIS_TLS_INSECURE", "false") == "true",
+		TLSCAFile:   GetEnv("REDIS_TLS_CA_FILE", ""),
+		Retries:     GetEnvInt("REDIS_CONNECT_RETRIES", 5),
+		RetryDelay:  time.Duration(GetEnvInt("REDIS_CONNECT_RETRY_DELAY_MS", 1000)) * time.Millisecond,
+	}
+}
+
+// RegisterFlags adds the flags overriding the Redis configuration.
+func (c *RedisConfig) RegisterFlags(flags *flag.FlagSet) {
+	flags.StringVar(&c.Addr, "redis-addr", c.Addr, "address of the Redis server (REDIS_ADDR)")
+	flags.StringVar(&c.Username, "redis-username", c.Username, "Redis username (REDIS_USERNAME)")
+	flags.StringVar(&c.Password, "redis-password", c.Password, "Redis password (REDIS_PASSWORD)")
+	flags.IntVar(&c.DB, "redis-db", c.DB, "Redis database (REDIS_DB)")
+	flags.BoolVar(&c.TLS, "redis-tls", c.TLS, "connect to Redis with TLS (REDIS_TLS)")
+	flags.BoolVar(&c.TLSInsecure, "redis-tls-insecure", c.TLSInsecure, "don't verify the certificate of the Redis server (REDIS_TLS_INSECURE)")
+	flags.StringVar(&c.TLSCAFile, "redis-tls-ca-file", c.TLSCAFile, "PEM file of the CA certificates of the Redis server (REDIS_TLS_CA_FILE)")
+	flags.IntVar(&c.Retries, "redis-retries", c.Retries, "number of connection attempts (REDIS_CONNECT_RETRIES)")
+}
+
+// ConnectRedis creates the Redis client and checks the server:
+//   - the server is pinged, with retries (the delay doubles after each attempt),
+//   - the RediSearch module (FT.* commands) must be available.
+func ConnectRedis(ctx context.Context, config RedisConfig) (*redis.Client, error) {
+	options := &redis.Options{
+		Addr:     config.Addr,
+		Username: config.Username,
+		Password: config.Password,
+		DB:       config.DB,
+		Protocol: 2,
+	}
+	if config.TLS {
+		options.TLSConfig = &tls.Config{
+			MinVersion:         tls.VersionTLS12,
+			InsecureSkipVerify: config.TLSInsecure,
+		}
+		if config.TLSCAFile != "" {
+			pem, err := os.ReadFile(config.TLSCAFile)
+			if err != nil {
+				return nil, fmt.Errorf("reading the Redis CA file: %w", err)
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no PEM certificate in the Redis CA file %s", config.TLSCAFile)
+			}
+			options.TLSConfig.RootCAs = pool
+		}
+	}
+	rdb := redis.NewClient(options)
+
+	attempts := max(1, config.Retries)
+	delay := config.RetryDelay
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if err = rdb.Ping(ctx).Err(); err == nil {
+			break
+		}
+		if attempt < attempts {
+			log.Printf("⏳ Redis is not ready at %s (attempt %d/%d): %v, retrying in %v", config.Addr, attempt, attempts, err, delay)
+			time.Sleep(delay)
+			delay *= 2
+		}
+	}
+	if err != nil {
+		rdb.Close()
+		return nil, fmt.Errorf("unable to connect to Redis at %s after %d attempts: %w", config.Addr, attempts, err)
+	}
+
+	if err := rdb.Do(ctx, "FT._LIST").Err(); err != nil {
+		rdb.Close()
+		return nil, fmt.Errorf("the RediSearch module is not available on %s (use Redis 8 or Redis Stack): %w", config.Addr, err)
+	}
+	return rdb, nil
+}
+
+// InitializeIndex deletes any index previously created with the same name (and its documents),
+// then creates the index.
+func InitializeIndex(ctx context.Context, rdb *redis.Client, indexName, keyPrefix string, settings IndexSettings) error {
+	rdb.FTDropIndexWithArgs(ctx,
+		indexName,
+		&redis.FTDropIndexOptions{
+			DeleteDocs: true,
+		},
+	)
+	/*
+		Next, create the index.
+		The schema in the example below specifies hash objects for storage and includes these fields:
+		 - the text content to index,
+		 - tag fields to filter the search: the tags of the document (front matter and directory names),
+		   its language and the name of the source document,
+		 - and the embedding vector generated from the original text content.
+		The embedding field specifies the indexing algorithm (HNSW or FLAT), the vector distance metric (COSINE, IP or L2),
+		Float32 values to represent the vector's components, and 1024 dimensions, as required by the mxbai-embed-large embedding model.
+		See IndexSettingsFromEnv.
+	*/
+	_, err := rdb.FTCreate(ctx,
+		indexName,
+		&redis.FTCreateOptions{
+			OnHash: true,
+			Prefix: []any{keyPrefix},
+		},
+		&redis.FieldSchema{
+			FieldName: "content",
+			FieldType: redis.SearchFieldTypeText,
+		},
+		&redis.FieldSchema{
+			FieldName: "tags",
+			FieldType: redis.SearchFieldTypeTag,
+			Separator: ",",
+		},
+		&redis.FieldSchema{
+			FieldName: "lang",
+			FieldType: redis.SearchFieldTypeTag,
+		},
+		&redis.FieldSchema{
+			FieldName: "source_name",
+			FieldType: redis.SearchFieldTypeTag,
+		},
+		&redis.FieldSchema{
+			FieldName:  "embedding",
+			FieldType:  redis.SearchFieldTypeVector,
+			VectorArgs: settings.VectorArgs(),
+		},
+	).Result()
+
+	if err != nil {
+		log.Println("😡 Error creating index:", err)
+		return err
+	}
+	return nil
+}
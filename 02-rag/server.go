@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// NewServer returns the HTTP handler of the query server:
+//   - GET /ask?question=...: streams the answer of Bob (text/plain)
+//   - GET /search?question=...: returns the most similar chunks (JSON)
+//   - GET /health: returns 200 when the server is up
+//
+// /ask and /search accept filter parameters (e.g. &filter=faq&filter=lang:fr), see ParseFilters,
+// and a kb parameter to query another knowledge base than the one of the server.
+func NewServer(rag *RAG) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /ask", func(w http.ResponseWriter, r *http.Request) {
+		question := r.URL.Query().Get("question")
+		if question == "" {
+			http.Error(w, "the question parameter is required", http.StatusBadRequest)
+			return
+		}
+		filters, err := ParseFilters(r.URL.Query()["filter"])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		rag, err := knowledgeBaseRAG(r, rag)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if !rag.Grounding.Enabled {
+			if _, err := rag.Ask(r.Context(), question, filters, w); err != nil {
+				log.Println("😡 Error answering the question:", err)
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+		//? the groundedness report is streamed after the answer
+		_, report, err := rag.AskGrounded(r.Context(), question, filters, w)
+		if err != nil {
+			log.Println("😡 Error answering the question:", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte("\n\n" + GroundednessSummary(report, rag.Grounding.Threshold) + "\n"))
+	})
+
+	mux.HandleFunc("GET /search", func(w http.ResponseWriter, r *http.Request) {
+		question := r.URL.Query().Get("question")
+		if question == "" {
+			http.Error(w, "the question parameter is required", http.StatusBadRequest)
+			return
+		}
+		filters, err := ParseFilters(r.URL.Query()["filter"])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		rag, err := knowledgeBaseRAG(r, rag)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		similarities, err := rag.Search(r.Context(), question, filters)
+		if err != nil {
+			log.Println("😡 Error searching similarities:", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(similarities)
+	})
+
+	mux.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+
+	return mux
+}
+
+// knowledgeBaseRAG returns the RAG of the knowledge base of the kb parameter (the RAG of the server by default).
+func knowledgeBaseRAG(r *http.Request, rag *RAG) (*RAG, error) {
+	name := r.URL.Query().Get("kb")
+	if name == "" {
+		return rag, nil
+	}
+	return rag.ForKnowledgeBase(r.Context(), name)
+}
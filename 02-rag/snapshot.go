@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// A snapshot is a directory with:
+//   - manifest.json: the embedding model, the dimension, the chunker and index settings,
+//   - chunks.jsonl: one chunk (text and metadata) per line,
+//   - vectors.bin: the vectors of the chunks, in the same order, as little-endian float32,
+//   - parents.jsonl: the parent chunks (parent-document retrieval), they have no vector.
+//
+// It can be imported into any vector store without calling the embedding model.
+const (
+	snapshotVersion  = 1
+	manifestFileName = "manifest.json"
+	chunksFileName   = "chunks.jsonl"
+	vectorsFileName  = "vectors.bin"
+	parentsFileName  = "parents.jsonl"
+)
+
+// SnapshotManifest describes the content of a snapshot.
+type SnapshotManifest struct {
+	Version         int           `json:"version"`
+	EmbeddingsModel string        `json:"embeddings_model"`
+	Dimension       int           `json:"dimension"`
+	ChunkSize       int           `json:"chunk_size"`
+	ChunkOverlap    int           `json:"chunk_overlap"`
+	ParentSize      int           `json:"parent_chunk_size,omitempty"`
+	ParentOverlap   int           `json:"parent_chunk_overlap,omitempty"`
+	Index           IndexSettings `json:"index"`
+	Count           int           `json:"count"`
+	Parents         int           `json:"parents,omitempty"`
+}
+
+// SnapshotChunk is a line of chunks.jsonl.
+type SnapshotChunk struct {
+	ID     string            `json:"id"`     // the Redis key without the key prefix
+	Fields map[string]string `json:"fields"` // all the fields of the Redis hash, except the embedding
+	// (the key of the parent chunk is stored without the key prefix too)
+}
+
+// ExportSnapshot writes all the chunks of the index (text, metadata and vectors) to a snapshot directory.
+func (r *RAG) ExportSnapshot(ctx context.Context, dirPath string) (SnapshotManifest, error) {
+	manifest := SnapshotManifest{
+		Version:         snapshotVersion,
+		EmbeddingsModel: r.EmbeddingsModel,
+		Dimension:       r.Index.Dim,
+		ChunkSize:       r.ChunkSize,
+		ChunkOverlap:    r.ChunkOverlap,
+		ParentSize:      r.ParentChunkSize,
+		ParentOverlap:   r.ParentChunkOverlap,
+		Index:           r.Index,
+	}
+
+	keys := []string{}
+	iter := r.Redis.Scan(ctx, 0, r.KeyPrefix+"*", 1000).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return manifest, err
+	}
+	sort.Strings(keys)
+
+	if err := os.MkdirAll(dirPath, 0o755); err != nil {
+		return manifest, err
+	}
+	chunksFile, err := os.Create(filepath.Join(dirPath, chunksFileName))
+	if err != nil {
+		return manifest, err
+	}
+	defer chunksFile.Close()
+	vectorsFile, err := os.Create(filepath.Join(dirPath, vectorsFileName))
+	if err != nil {
+		return manifest, err
+	}
+	defer vectorsFile.Close()
+	parents := []SnapshotChunk{}
+
+	chunksWriter := bufio.NewWriter(chunksFile)
+	vectorsWriter := bufio.NewWriter(vectorsFile)
+	encoder := json.NewEncoder(chunksWriter)
+
+	for _, key := range keys {
+		fields, err := r.Redis.HGetAll(ctx, key).Result()
+		if err != nil {
+			return manifest, err
+		}
+		id := strings.TrimPrefix(key, r.KeyPrefix)
+		if fields["kind"] == "parent" {
+			parents = append(parents, SnapshotChunk{ID: id, Fields: fields})
+			continue
+		}
+		embedding, ok := fields["embedding"]
+		if !ok {
+			continue // not a chunk
+		}
+		vector := bytesToFloats([]byte(embedding))
+		if len(vector) != manifest.Dimension {
+			return manifest, fmt.Errorf("%s: the vector has %d dimensions instead of %d", key, len(vector), manifest.Dimension)
+		}
+		delete(fields, "embedding")
+		if parent, ok := fields["parent"]; ok && parent != "" {
+			fields["parent"] = strings.TrimPrefix(parent, r.KeyPrefix)
+		}
+
+		if err := encoder.Encode(SnapshotChunk{ID: id, Fields: fields}); err != nil {
+			return manifest, err
+		}
+		if err := binary.Write(vectorsWriter, binary.LittleEndian, vector); err != nil {
+			return manifest, err
+		}
+		manifest.Count++
+	}
+
+	if err := chunksWriter.Flush(); err != nil {
+		return manifest, err
+	}
+	if err := vectorsWriter.Flush(); err != nil {
+		return manifest, err
+	}
+	if len(parents) > 0 {
+		if err := writeSnapshotParents(filepath.Join(dirPath, parentsFileName), parents); err != nil {
+			return manifest, err
+		}
+		manifest.Parents = len(parents)
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return manifest, err
+	}
+	return manifest, os.WriteFile(filepath.Join(dirPath, manifestFileName), data, 0o644)
+}
+
+// writeSnapshotParents writes the parent chunks to parents.jsonl.
+func writeSnapshotParents(path string, parents []SnapshotChunk) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	for _, parent := range parents {
+		if err := encoder.Encode(parent); err != nil {
+			return err
+		}
+	}
+	return writer.Flush()
+}
+
+// ReadSnapshotManifest reads the manifest of a snapshot directory.
+func ReadSnapshotManifest(dirPath string) (SnapshotManifest, error) {
+	var manifest SnapshotManifest
+	data, err := os.ReadFile(filepath.Join(dirPath, manifestFileName))
+	if err != nil {
+		return manifest, err
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return manifest, err
+	}
+	if manifest.Version != snapshotVersion {
+		return manifest, fmt.Errorf("unsupported snapshot version %d", manifest.Version)
+	}
+	return manifest, nil
+}
+
+// ReadSnapshot reads the chunks and the vectors of a snapshot, and calls fn for each of them.
+func ReadSnapshot(dirPath string, manifest SnapshotManifest, fn func(chunk SnapshotChunk, vector []float32) error) error {
+	chunksFile, err := os.Open(filepath.Join(dirPath, chunksFileName))
+	if err != nil {
+		return err
+	}
+	defer chunksFile.Close()
+	vectorsFile, err := os.Open(filepath.Join(dirPath, vectorsFileName))
+	if err != nil {
+		return err
+	}
+	defer vectorsFile.Close()
+
+	decoder := json.NewDecoder(bufio.NewReader(chunksFile))
+	vectorsReader := bufio.NewReader(vectorsFile)
+	buffer := make([]byte, manifest.Dimension*4)
+
+	for count := 0; ; count++ {
+		var chunk SnapshotChunk
+		if err := decoder.Decode(&chunk); err == io.EOF {
+			if count != manifest.Count {
+				return fmt.Errorf("%d chunks in the snapshot, %d expected", count, manifest.Count)
+			}
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(vectorsReader, buffer); err != nil {
+			return fmt.Errorf("reading the vector of %s: %w", chunk.ID, err)
+		}
+		vector := make([]float32, manifest.Dimension)
+		for idx := range vector {
+			vector[idx] = math.Float32frombits(binary.LittleEndian.Uint32(buffer[idx*4:]))
+		}
+		if err := fn(chunk, vector); err != nil {
+			return err
+		}
+	}
+}
+
+// ImportSnapshot re-creates the index with the settings of the snapshot
+// and stores the chunks with their vectors (the embedding model is not called).
+func (r *RAG) ImportSnapshot(ctx context.Context, dirPath string) (SnapshotManifest, error) {
+	manifest, err := ReadSnapshotManifest(dirPath)
+	if err != nil {
+		return manifest, err
+	}
+
+	// the vectors must be compared with the query vectors the same way as when they were created
+	r.Index = manifest.Index
+	r.Index.Dim = manifest.Dimension
+	if err := r.InitializeIndex(ctx); err != nil {
+		return manifest, err
+	}
+
+	err = ReadSnapshot(dirPath, manifest, func(chunk SnapshotChunk, vector []float32) error {
+		values := map[string]any{"embedding": floatsToBytes(vector)}
+		for name, value := range chunk.Fields {
+			values[name] = value
+		}
+		if parent := chunk.Fields["parent"]; parent != "" {
+			values["parent"] = r.KeyPrefix + parent
+		}
+		return r.Redis.HSet(ctx, r.KeyPrefix+chunk.ID, values).Err()
+	})
+	if err != nil || manifest.Parents == 0 {
+		return manifest, err
+	}
+
+	file, err := os.Open(filepath.Join(dirPath, parentsFileName))
+	if err != nil {
+		return manifest, err
+	}
+	defer file.Close()
+	decoder := json.NewDecoder(bufio.NewReader(file))
+	for {
+		var parent SnapshotChunk
+		if err := decoder.Decode(&parent); err == io.EOF {
+			return manifest, nil
+		} else if err != nil {
+			return manifest, err
+		}
+		if err := r.Redis.HSet(ctx, r.KeyPrefix+parent.ID, parent.Fields).Err(); err != nil {
+			return manifest, err
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// fileState is what we compare between two scans of the docs directory to detect a change.
+type fileState struct {
+	ModTime time.Time
+	Size    int64
+}
+
+// ScanDirectory returns the state of every supported document of a directory and its subdirectories.
+func ScanDirectory(dirPath string) (map[string]fileState, error) {
+	states := map[string]fileState{}
+	err := filepath.Walk(dirPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		if _, ok := GetLoader(path); ok {
+			states[path] = fileState{ModTime: info.ModTime(), Size: info.Size()}
+		}
+		return nil
+	})
+	return states, err
+}
+
+// WatchDirectory polls a directory and calls onChange with the changed (created or modified)
+// and the removed documents.
+// A burst of changes is debounced: onChange is called once nothing has changed during the debounce delay.
+// WatchDirectory blocks until the context is canceled.
+//
+// Parameters:
+//   - ctx: The context to stop watching.
+//   - dirPath: The directory to watch.
+//   - interval: The delay between two scans of the directory.
+//   - debounce: The quiet period to wait before reporting the changes.
+//   - onChange: The function to call with the changes.
+func WatchDirectory(ctx context.Context, dirPath string, interval, debounce time.Duration, onChange func(changed, removed []string)) error {
+	previous, err := ScanDirectory(dirPath)
+	if err != nil {
+		return err
+	}
+
+	pending := map[string]bool{} // path -> true if the file still exists
+	lastChange := time.Time{}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		current, err := ScanDirectory(dirPath)
+		if err != nil {
+			log.Println("😡 Error scanning the documents:", err)
+			continue
+		}
+
+		for path, state := range current {
+			if before, ok := previous[path]; !ok || before != state {
+				pending[path] = true
+				lastChange = time.Now()
+			}
+		}
+		for path := range previous {
+			if _, ok := current[path]; !ok {
+				pending[path] = false
+				lastChange = time.Now()
+			}
+		}
+		previous = current
+
+		if len(pending) == 0 || time.Since(lastChange) < debounce {
+			continue
+		}
+
+		changed, removed := []string{}, []string{}
+		for path, exists := range pending {
+			if exists {
+				changed = append(changed, path)
+			} else {
+				removed = append(removed, path)
+			}
+		}
+		sort.Strings(changed)
+		sort.Strings(removed)
+		pending = map[string]bool{}
+
+		onChange(changed, removed)
+	}
+}
+
+// ReindexChanges upserts the chunks of the changed documents and deletes the chunks of the removed ones.
+// With deduplication, the chunk of a document can be the canonical chunk of duplicates of other documents,
+// so all the documents are re-indexed.
+func (r *RAG) ReindexChanges(ctx context.Context, docsDir string, changed, removed []string) {
+	if r.Dedup.Enabled {
+		r.reindexAll(ctx, docsDir, removed)
+		return
+	}
+	for _, path := range removed {
+		deleted, err := r.DeleteSource(ctx, path)
+		if err != nil {
+			log.Println("😡 Error deleting the chunks of", path, err)
+			continue
+		}
+		log.Println("🗑️ ", path, "removed,", deleted, "chunks deleted")
+	}
+
+	for _, path := range changed {
+		sections, err := LoadFile(docsDir, path)
+		if err != nil {
+			log.Println("😡 Error loading", path, err)
+			continue
+		}
+		if len(sections) == 0 {
+			// e.g. an empty CSV file: nothing to search anymore
+			if _, err := r.DeleteSource(ctx, path); err != nil {
+				log.Println("😡 Error deleting the chunks of", path, err)
+			}
+			continue
+		}
+		report, err := r.IndexSections(ctx, sections)
+		if err != nil {
+			log.Println("😡 Error indexing", path, err)
+			continue
+		}
+		log.Println("🔄", path, "re-indexed,", report.Indexed, "chunks")
+		for _, failure := range report.Failures {
+			log.Println("😡 Failed chunk", failure.Key, "of", path, failure.Error)
+		}
+	}
+}
+
+// reindexAll deletes the chunks of the removed documents and re-indexes all the documents
+// (the embedding cache avoids embedding the unchanged chunks again).
+func (r *RAG) reindexAll(ctx context.Context, docsDir string, removed []string) {
+	for _, path := range removed {
+		if _, err := r.DeleteSource(ctx, path); err != nil {
+			log.Println("😡 Error deleting the chunks of", path, err)
+		}
+	}
+	sections, err := LoadDocuments(docsDir)
+	if err != nil {
+		log.Println("😡 Error reading documents:", err)
+		return
+	}
+	report, err := r.IndexSections(ctx, sections)
+	if err != nil {
+		log.Println("😡 Error indexing documents:", err)
+		return
+	}
+	log.Println("🔄", docsDir, "re-indexed,", report.Indexed, "chunks,", len(report.Duplicates), "duplicates merged")
+	for _, failure := range report.Failures {
+		log.Println("😡 Failed chunk", failure.Key, "of", failure.Chunk.Source, failure.Error)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/packages/param"
+)
+
+// ErrMaxIterations is returned when the model still calls tools after the maximum number of iterations.
+var ErrMaxIterations = errors.New("maximum number of iterations reached")
+
+// ToolExecutor executes a tool call and returns the result sent back to the model.
+type ToolExecutor func(ctx context.Context, toolCall openai.ChatCompletionMessageToolCall) string
+
+// ToolFilter reports whether a tool call has a property, e.g. whether it must run sequentially.
+type ToolFilter func(toolCall openai.ChatCompletionMessageToolCall) bool
+
+// ToolReviewer decides whether a tool call can be executed.
+// When it can't, it returns false with the result sent back to the model instead.
+type ToolReviewer func(ctx context.Context, toolCall openai.ChatCompletionMessageToolCall) (string, bool)
+
+// Agent runs the tool-calling loop:
+//   - the model is called with the messages and the tools,
+//   - the assistant message (with its tool calls) is appended to the messages,
+//     the tool calls are executed concurrently (see ExecuteToolCalls),
+//     then one tool message per tool call (by toolCall.ID, in the order of the calls) with the result of the tool,
+//   - the model is called again, until it answers with plain content (or MaxIterations is reached).
+//
+// The content of the answers is streamed to the output as it arrives.
+//
+// In prompt mode (see ToolCallingPrompt), the tools are described in the system prompt instead:
+// the tool calls are parsed from the text of the model (see ParseToolCalls),
+// the results are sent back in a user message (see PromptToolResults),
+// and the final answer is written to the output once complete.
+type Agent struct {
+	Client        openai.Client
+	Params        openai.ChatCompletionNewParams // model, tools, temperature... (the messages are set by Run)
+	MaxIterations int
+	Execute       ToolExecutor
+	Review        ToolReviewer  // reviews the tool calls before their execution, one at a time (nil: no review)
+	Sequential    ToolFilter    // the tool calls running one at a time in the order of the calls (nil: none)
+	Concurrency   int           // maximum number of tool calls running at the same time
+	ToolTimeout   time.Duration // maximum duration of a tool call (0: no timeout)
+	Mode          string        // ToolCallingNative (default) or ToolCallingPrompt
+	Output        io.Writer
+}
+
+// Run runs the loop with the messages of the conversation.
+// It returns the final answer and the messages of the conversation (including the tool calls and results).
+func (a *Agent) Run(ctx context.Context, messages []openai.ChatCompletionMessageParamUnion) (string, []openai.ChatCompletionMessageParamUnion, error) {
+	if a.Mode == ToolCallingPrompt {
+		return a.runPrompt(ctx, messages)
+	}
+	for iteration := 1; iteration <= a.MaxIterations; iteration++ {
+		params := a.Params
+		params.Messages = messages
+
+		message, err := a.stream(ctx, params)
+		if err != nil {
+			return "", messages, err
+		}
+		messages = append(messages, message.ToParam())
+
+		// no tool call: this is the final answer
+		if len(message.ToolCalls) == 0 {
+			return message.Content, messages, nil
+		}
+
+		results := a.executeToolCalls(ctx, message.ToolCalls, iteration)
+		for idx, toolCall := range message.ToolCalls {
+			messages = append(messages, openai.ToolMessage(results[idx], toolCall.ID))
+		}
+	}
+	return "", messages, ErrMaxIterations
+}
+
+// runPrompt runs the loop in prompt mode: the tools are described in the system prompt,
+// and the model answers with a JSON envelope of tool calls, or with the final answer.
+func (a *Agent) runPrompt(ctx context.Context, messages []openai.ChatCompletionMessageParamUnion) (string, []openai.ChatCompletionMessageParamUnion, error) {
+	messages = append([]openai.ChatCompletionMessageParamUnion{
+		openai.SystemMessage(PromptToolsInstructions(a.Params.Tools)),
+	}, messages...)
+
+	for iteration := 1; iteration <= a.MaxIterations; iteration++ {
+		params := a.Params
+		// the tools are described in the system prompt
+		params.Tools = nil
+		params.ParallelToolCalls = param.Opt[bool]{} // only sent with the tools
+		params.Messages = messages
+
+		completion, err := a.Client.Chat.Completions.New(ctx, params)
+		if err != nil {
+			return "", messages, err
+		}
+		if len(completion.Choices) == 0 {
+			return "", messages, errors.New("no choice in the completion")
+		}
+		content := completion.Choices[0].Message.Content
+		messages = append(messages, openai.AssistantMessage(content))
+
+		toolCalls, err := ParseToolCalls(content, a.Params.Tools)
+		if err != nil {
+			// the model tried to call tools with an invalid envelope: it gets the error to fix it
+			messages = append(messages, openai.UserMessage("Error: "+err.Error()+"\nAnswer ONLY with a valid JSON envelope."))
+			continue
+		}
+		// no tool call: this is the final answer
+		if len(toolCalls) == 0 {
+			io.WriteString(a.Output, content)
+			return content, messages, nil
+		}
+
+		results := a.executeToolCalls(ctx, toolCalls, iteration)
+		messages = append(messages, openai.UserMessage(PromptToolResults(toolCalls, results)))
+	}
+	return "", messages, ErrMaxIterations
+}
+
+// executeToolCalls executes the tool calls of a turn, and displays them with their results.
+func (a *Agent) executeToolCalls(ctx context.Context, toolCalls []openai.ChatCompletionMessageToolCall, iteration int) []string {
+	fmt.Println("🤖 Tool calls:", len(toolCalls), "(iteration", iteration, ")")
+	for _, toolCall := range toolCalls {
+		fmt.Println("🤖 Function call:", toolCall.Function.Name, toolCall.Function.Arguments)
+	}
+
+	// the calls are reviewed one by one (e.g. approved by the user) before the concurrent execution
+	results := make([]string, len(toolCalls))
+	approved := []openai.ChatCompletionMessageToolCall{}
+	positions := []int{}
+	for idx, toolCall := range toolCalls {
+		if a.Review != nil {
+			if result, ok := a.Review(ctx, toolCall); !ok {
+				results[idx] = result
+				continue
+			}
+		}
+		approved = append(approved, toolCall)
+		positions = append(positions, idx)
+	}
+	for idx, result := range ExecuteToolCalls(ctx, approved, a.Concurrency, a.ToolTimeout, a.Execute, a.Sequential) {
+		results[positions[idx]] = result
+	}
+
+	for idx, toolCall := range toolCalls {
+		fmt.Println("--------------------------------------------")
+		fmt.Println("🛠️ ", toolCall.Function.Name, "result:")
+		fmt.Println("--------------------------------------------")
+		fmt.Println(results[idx])
+	}
+	return results
+}
+
+// stream calls the model, writes the content to the output as it arrives,
+// and returns the complete assistant message (content and tool calls).
+func (a *Agent) stream(ctx context.Context, params openai.ChatCompletionNewParams) (openai.ChatCompletionMessage, error) {
+	stream := a.Client.Chat.Completions.NewStreaming(ctx, params)
+	defer stream.Close()
+
+	// the accumulator assembles the tool calls from their deltas (by index) while the content is streamed
+	accumulator := openai.ChatCompletionAccumulator{}
+	for stream.Next() {
+		chunk := stream.Current()
+		accumulator.AddChunk(chunk)
+		if len(chunk.Choices) > 0 && chunk.Choices[0].Delta.Content != "" {
+			io.WriteString(a.Output, chunk.Choices[0].Delta.Content)
+		}
+	}
+	if err := stream.Err(); err != nil {
+		return openai.ChatCompletionMessage{}, err
+	}
+	if len(accumulator.Choices) == 0 {
+		return openai.ChatCompletionMessage{}, errors.New("no choice in the completion")
+	}
+	return accumulator.Choices[0].Message, nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/openai/openai-go"
+)
+
+// Approval modes of the side-effecting tool calls:
+//   - prompt: the user confirms each call in the terminal,
+//   - auto: the calls are approved without asking,
+//   - deny: the calls are denied without asking.
+const (
+	ApprovalPrompt = "prompt"
+	ApprovalAuto   = "auto"
+	ApprovalDeny   = "deny"
+)
+
+// ApprovalFunc decides whether a side-effecting tool call can be executed.
+type ApprovalFunc func(ctx context.Context, toolCall openai.ChatCompletionMessageToolCall) (bool, error)
+
+// NewApproval returns the approval function of the mode, the prompt reads the answers from the input.
+// The prompt falls back to deny when there is no input (e.g. docker compose up doesn't attach
+// the standard input, so nobody can answer): the fallback is reported on the output.
+func NewApproval(mode string, in io.Reader, out io.Writer) (ApprovalFunc, error) {
+	if mode == ApprovalPrompt && !hasInput(in) {
+		fmt.Fprintln(out, "⚠️ TOOL_APPROVAL=prompt but there is no input to read the answers from (e.g. docker compose up): "+
+			"the calls of the side-effecting tools are denied. Run it with docker compose run --rm <service> to answer the prompt, "+
+			"or TOOL_APPROVAL=auto to approve them.")
+		mode = ApprovalDeny
+	}
+	switch mode {
+	case ApprovalPrompt:
+		return PromptApproval(in, out), nil
+	case ApprovalAuto:
+		return func(ctx context.Context, toolCall openai.ChatCompletionMessageToolCall) (bool, error) {
+			return true, nil
+		}, nil
+	case ApprovalDeny:
+		return func(ctx context.Context, toolCall openai.ChatCompletionMessageToolCall) (bool, error) {
+			return false, nil
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown approval mode %q (expected %s, %s or %s)", mode, ApprovalPrompt, ApprovalAuto, ApprovalDeny)
+	}
+}
+
+// PromptApproval returns an approval function asking the user:
+// it shows the tool call (see JSONPretty) and waits for y(es) or n(o) on the input.
+// Any other answer, and the end of the input (e.g. no terminal attached), deny the call.
+// The questions are asked one at a time.
+func PromptApproval(in io.Reader, out io.Writer) ApprovalFunc {
+	reader := bufio.NewReader(in)
+	var mutex sync.Mutex
+
+	return func(ctx context.Context, toolCall openai.ChatCompletionMessageToolCall) (bool, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		fmt.Fprintln(out, "--------------------------------------------")
+		fmt.Fprintln(out, "✋ The model wants to call", toolCall.Function.Name, "(this tool has side effects):")
+		fmt.Fprintln(out, JSONPretty(toolCall))
+		fmt.Fprint(out, "❓ Approve this call? [y/N] ")
+
+		answer, err := reader.ReadString('\n')
+		if err == io.EOF && answer == "" {
+			fmt.Fprintln(out, "\n🚫 denied: no answer (end of the input)")
+			return false, nil
+		}
+		answer = strings.ToLower(strings.TrimSpace(answer))
+		approved := answer == "y" || answer == "yes"
+		if !approved {
+			fmt.Fprintln(out, "🚫 denied")
+		}
+		return approved, nil
+	}
+}
+
+// hasInput reports whether the answers can be read from the input: it is not the null device
+// (the standard input of a container started by docker compose up is /dev/null).
+// The inputs other than files (e.g. a strings.Reader) can be read.
+func hasInput(in io.Reader) bool {
+	file, ok := in.(*os.File)
+	if !ok {
+		return true
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	null, err := os.Stat(os.DevNull)
+	return err != nil || !os.SameFile(info, null)
+}
+
+// Review asks the approval of the tool call when its tool has side effects (see WithSideEffects).
+// It returns false with the result sent back to the model when the call is denied,
+// so the model can react (e.g. ask the user what to change).
+func (r *Registry) Review(ctx context.Context, toolCall openai.ChatCompletionMessageToolCall) (string, bool) {
+	tool := r.Get(toolCall.Function.Name)
+	if tool == nil || !tool.SideEffects || r.Approve == nil {
+		return "", true
+	}
+	approved, err := r.Approve(ctx, toolCall)
+	if err != nil {
+		return fmt.Sprintf("Error: %s: approval: %v", tool.Name, err), false
+	}
+	if !approved {
+		return fmt.Sprintf("Denied: the user didn't approve the call of %s with these arguments, it was not executed. "+
+			"Don't call it again without asking the user what to change.", tool.Name), false
+	}
+	return "", true
+}
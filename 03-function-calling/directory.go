@@ -0,0 +1,314 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // the time zones of the opening hours (the image is built from scratch)
+	"unicode"
+)
+
+//go:embed data/pizzerias.json
+var defaultDirectory []byte
+
+// Coordinates are the geographic coordinates of a place, in degrees.
+type Coordinates struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// Pizzeria is an entry of the pizzeria directory.
+type Pizzeria struct {
+	Name        string              `json:"name"`
+	Address     string              `json:"address"`
+	City        string              `json:"city"`
+	Country     string              `json:"country"`
+	Coordinates Coordinates         `json:"coordinates"`
+	TimeZone    string              `json:"timezone"` // IANA time zone of the opening hours, e.g. Europe/Paris (UTC when empty)
+	Hours       map[string][]string `json:"hours"`    // opening spans by day (mon...sun), e.g. "tue": ["11:30-14:30", "18:30-22:30"]
+	Specialties []string            `json:"specialties"`
+}
+
+// Directory is the list of the pizzerias, searchable by city.
+type Directory struct {
+	Pizzerias []Pizzeria
+}
+
+var weekDays = []string{"mon", "tue", "wed", "thu", "fri", "sat", "sun"}
+
+var hoursSpan = regexp.MustCompile(`^([01]\d|2[0-3]):[0-5]\d-([01]\d|2[0-4]):[0-5]\d$`)
+
+// LoadDirectory loads the pizzeria directory from a JSON or a CSV file (by extension).
+// With an empty path, the directory embedded in the program (data/pizzerias.json) is used.
+//
+// The CSV file has a header with the columns name, address, city, country, latitude, longitude, timezone, hours and specialties:
+//   - hours: the days separated by ";", e.g. tue=11:30-14:30|18:30-22:30;wed=11:30-14:30
+//   - specialties: separated by "|"
+func LoadDirectory(path string) (*Directory, error) {
+	var pizzerias []Pizzeria
+	var err error
+	switch {
+	case path == "":
+		err = json.Unmarshal(defaultDirectory, &pizzerias)
+	case strings.EqualFold(filepath.Ext(path), ".json"):
+		var data []byte
+		if data, err = os.ReadFile(path); err == nil {
+			err = json.Unmarshal(data, &pizzerias)
+		}
+	case strings.EqualFold(filepath.Ext(path), ".csv"):
+		var file *os.File
+		if file, err = os.Open(path); err == nil {
+			defer file.Close()
+			pizzerias, err = readPizzeriasCSV(file)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported pizzeria directory %s (expected a .json or .csv file)", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("loading the pizzeria directory %s: %w", path, err)
+	}
+
+	for idx, pizzeria := range pizzerias {
+		if err := pizzeria.Validate(); err != nil {
+			return nil, fmt.Errorf("pizzeria #%d (%s): %w", idx+1, pizzeria.Name, err)
+		}
+	}
+	return &Directory{Pizzerias: pizzerias}, nil
+}
+
+// Validate checks the required fields, the coordinates, the time zone and the opening hours of the pizzeria.
+func (p Pizzeria) Validate() error {
+	if p.Name == "" || p.City == "" {
+		return fmt.Errorf("the name and the city are required")
+	}
+	if p.Coordinates.Latitude < -90 || p.Coordinates.Latitude > 90 ||
+		p.Coordinates.Longitude < -180 || p.Coordinates.Longitude > 180 {
+		return fmt.Errorf("invalid coordinates %v", p.Coordinates)
+	}
+	if _, err := time.LoadLocation(p.TimeZone); err != nil {
+		return fmt.Errorf("invalid time zone: %w", err)
+	}
+	for day, spans := range p.Hours {
+		if !slices.Contains(weekDays, day) {
+			return fmt.Errorf("invalid day %q in the hours (expected %s)", day, strings.Join(weekDays, ", "))
+		}
+		for _, span := range spans {
+			if !hoursSpan.MatchString(span) {
+				return fmt.Errorf("invalid hours %q on %s (expected HH:MM-HH:MM)", span, day)
+			}
+		}
+	}
+	return nil
+}
+
+// readPizzeriasCSV reads the pizzerias of a CSV file (see LoadDirectory).
+func readPizzeriasCSV(r io.Reader) ([]Pizzeria, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	columns := map[string]int{}
+	for idx, name := range records[0] {
+		columns[strings.ToLower(strings.TrimSpace(name))] = idx
+	}
+	for _, name := range []string{"name", "address", "city", "country", "latitude", "longitude"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("missing column %s", name)
+		}
+	}
+	field := func(record []string, name string) string {
+		if idx, ok := columns[name]; ok && idx < len(record) {
+			return strings.TrimSpace(record[idx])
+		}
+		return ""
+	}
+
+	pizzerias := []Pizzeria{}
+	for line, record := range records[1:] {
+		latitude, err := strconv.ParseFloat(field(record, "latitude"), 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid latitude: %w", line+2, err)
+		}
+		longitude, err := strconv.ParseFloat(field(record, "longitude"), 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid longitude: %w", line+2, err)
+		}
+		hours := map[string][]string{}
+		for _, day := range splitNonEmpty(field(record, "hours"), ";") {
+			name, spans, _ := strings.Cut(day, "=")
+			hours[strings.ToLower(strings.TrimSpace(name))] = splitNonEmpty(spans, "|")
+		}
+		pizzerias = append(pizzerias, Pizzeria{
+			Name:        field(record, "name"),
+			Address:     field(record, "address"),
+			City:        field(record, "city"),
+			Country:     field(record, "country"),
+			Coordinates: Coordinates{Latitude: latitude, Longitude: longitude},
+			TimeZone:    field(record, "timezone"),
+			Hours:       hours,
+			Specialties: splitNonEmpty(field(record, "specialties"), "|"),
+		})
+	}
+	return pizzerias, nil
+}
+
+// splitNonEmpty splits the text, and returns the trimmed non-empty parts.
+func splitNonEmpty(text, separator string) []string {
+	parts := []string{}
+	for _, part := range strings.Split(text, separator) {
+		if part = strings.TrimSpace(part); part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return parts
+}
+
+// PizzeriaPage is a page of the pizzerias of a city.
+type PizzeriaPage struct {
+	Query       string     `json:"query"`
+	City        string     `json:"city,omitempty"` // the city of the directory matching the query
+	Total       int        `json:"total"`
+	Page        int        `json:"page"`
+	Pages       int        `json:"pages"`
+	Pizzerias   []Pizzeria `json:"pizzerias"`
+	Suggestions []string   `json:"suggestions,omitempty"` // the known cities, when no city matches the query
+}
+
+// FindByCity returns a page (starting at 1) of the pizzerias of the city matching the query.
+// The match is case- and accent-insensitive, and tolerates typos (see MatchCity).
+func (d *Directory) FindByCity(query string, page, pageSize int) PizzeriaPage {
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 {
+		pageSize = 5
+	}
+	result := PizzeriaPage{Query: query, Page: page, Pizzerias: []Pizzeria{}}
+
+	city, ok := MatchCity(query, d.Cities())
+	if !ok {
+		result.Suggestions = d.Cities()
+		return result
+	}
+	result.City = city
+
+	matches := []Pizzeria{}
+	for _, pizzeria := range d.Pizzerias {
+		if pizzeria.City == city {
+			matches = append(matches, pizzeria)
+		}
+	}
+	result.Total = len(matches)
+	result.Pages = (len(matches) + pageSize - 1) / pageSize
+	if start := (page - 1) * pageSize; start < len(matches) {
+		result.Pizzerias = matches[start:min(start+pageSize, len(matches))]
+	}
+	return result
+}
+
+// Cities returns the sorted cities of the directory.
+func (d *Directory) Cities() []string {
+	cities := []string{}
+	for _, pizzeria := range d.Pizzerias {
+		if !slices.Contains(cities, pizzeria.City) {
+			cities = append(cities, pizzeria.City)
+		}
+	}
+	sort.Strings(cities)
+	return cities
+}
+
+// MatchCity returns the city matching the query:
+//   - the same name, ignoring the case and the accents (e.g. "saint etienne" for "Saint-Étienne"),
+//   - else the city whose name starts with the query (e.g. "sao" for "São Paulo"),
+//   - else the closest city with a few typos (edit distance up to a quarter of the length of the name).
+//
+// A query with a country (e.g. "Lyon, France") is matched by its first part.
+func MatchCity(query string, cities []string) (string, bool) {
+	if before, _, found := strings.Cut(query, ","); found {
+		if city, ok := MatchCity(before, cities); ok {
+			return city, true
+		}
+	}
+	normalized := NormalizeName(query)
+	if normalized == "" {
+		return "", false
+	}
+
+	for _, city := range cities {
+		if NormalizeName(city) == normalized {
+			return city, true
+		}
+	}
+	for _, city := range cities {
+		if strings.HasPrefix(NormalizeName(city), normalized) {
+			return city, true
+		}
+	}
+	best, bestDistance := "", len(normalized)/4+1
+	for _, city := range cities {
+		if distance := levenshtein(NormalizeName(city), normalized); distance < bestDistance {
+			best, bestDistance = city, distance
+		}
+	}
+	return best, best != ""
+}
+
+var accents = strings.NewReplacer(
+	"à", "a", "á", "a", "â", "a", "ã", "a", "ä", "a", "å", "a", "æ", "ae",
+	"ç", "c", "è", "e", "é", "e", "ê", "e", "ë", "e",
+	"ì", "i", "í", "i", "î", "i", "ï", "i", "ñ", "n",
+	"ò", "o", "ó", "o", "ô", "o", "õ", "o", "ö", "o", "ø", "o", "œ", "oe",
+	"ù", "u", "ú", "u", "û", "u", "ü", "u", "ý", "y", "ÿ", "y", "ß", "ss",
+)
+
+// NormalizeName returns the name in lower case, without accents,
+// and with the punctuation replaced by single spaces (e.g. "Saint-Étienne" -> "saint etienne").
+func NormalizeName(name string) string {
+	name = accents.Replace(strings.ToLower(name))
+	name = strings.Map(func(char rune) rune {
+		if unicode.IsLetter(char) || unicode.IsDigit(char) {
+			return char
+		}
+		return ' '
+	}, name)
+	return strings.Join(strings.Fields(name), " ")
+}
+
+// levenshtein returns the edit distance between two strings.
+func levenshtein(a, b string) int {
+	source, target := []rune(a), []rune(b)
+	previous := make([]int, len(target)+1)
+	for idx := range previous {
+		previous[idx] = idx
+	}
+	for i := 1; i <= len(source); i++ {
+		current := make([]int, len(target)+1)
+		current[0] = i
+		for j := 1; j <= len(target); j++ {
+			cost := 1
+			if source[i-1] == target[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous = current
+	}
+	return previous[len(target)]
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+//go:embed data/gazetteer.json
+var defaultGazetteer []byte
+
+const earthRadiusKm = 6371.0
+
+// Haversine returns the great-circle distance between two places, in kilometers.
+func Haversine(a, b Coordinates) float64 {
+	lat1, lat2 := a.Latitude*math.Pi/180, b.Latitude*math.Pi/180
+	deltaLat := (b.Latitude - a.Latitude) * math.Pi / 180
+	deltaLon := (b.Longitude - a.Longitude) * math.Pi / 180
+
+	h := math.Sin(deltaLat/2)*math.Sin(deltaLat/2) +
+		math.Cos(lat1)*math.Cos(lat2)*math.Sin(deltaLon/2)*math.Sin(deltaLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// Place is an entry of the gazetteer: a named place with its coordinates.
+type Place struct {
+	Name        string      `json:"name"`
+	Aliases     []string    `json:"aliases"`
+	Coordinates Coordinates `json:"coordinates"`
+}
+
+// Gazetteer resolves the addresses and the place names to coordinates, without any external service.
+type Gazetteer struct {
+	Places []Place
+}
+
+// LoadGazetteer loads the gazetteer from a JSON file.
+// With an empty path, the gazetteer embedded in the program (data/gazetteer.json) is used.
+func LoadGazetteer(path string) (*Gazetteer, error) {
+	data := defaultGazetteer
+	if path != "" {
+		var err error
+		if data, err = os.ReadFile(path); err != nil {
+			return nil, fmt.Errorf("loading the gazetteer %s: %w", path, err)
+		}
+	}
+	var places []Place
+	if err := json.Unmarshal(data, &places); err != nil {
+		return nil, fmt.Errorf("loading the gazetteer %s: %w", path, err)
+	}
+	return &Gazetteer{Places: places}, nil
+}
+
+// Resolve returns the place of an address:
+//   - the place whose name or alias is the address (ignoring the case and the accents),
+//   - else the place with the longest name or alias contained in the address
+//     (e.g. "near Place Bellecour in Lyon" -> Place Bellecour),
+//   - else the closest name with a few typos.
+func (g *Gazetteer) Resolve(address string) (Place, bool) {
+	normalized := NormalizeName(address)
+	if normalized == "" {
+		return Place{}, false
+	}
+
+	best, bestLength := -1, 0
+	for idx, place := range g.Places {
+		for _, name := range append([]string{place.Name}, place.Aliases...) {
+			name = NormalizeName(name)
+			if name == normalized {
+				return place, true
+			}
+			if len(name) > bestLength && strings.Contains(" "+normalized+" ", " "+name+" ") {
+				best, bestLength = idx, len(name)
+			}
+		}
+	}
+	if best >= 0 {
+		return g.Places[best], true
+	}
+
+	bestDistance := len(normalized)/4 + 1
+	for idx, place := range g.Places {
+		for _, name := range append([]string{place.Name}, place.Aliases...) {
+			if distance := levenshtein(NormalizeName(name), normalized); distance < bestDistance {
+				best, bestDistance = idx, distance
+			}
+		}
+	}
+	if best >= 0 {
+		return g.Places[best], true
+	}
+	return Place{}, false
+}
+
+// IsOpen returns whether the pizzeria is open at the given time, in the time zone of the pizzeria.
+// A span ending after midnight (e.g. "18:00-01:00") is open until the end time of the next day.
+func (p Pizzeria) IsOpen(at time.Time) bool {
+	location, err := time.LoadLocation(p.TimeZone)
+	if err != nil {
+		location = time.UTC
+	}
+	at = at.In(location)
+	minute := at.Hour()*60 + at.Minute()
+	today := weekDays[(int(at.Weekday())+6)%7] // weekDays starts on Monday
+	yesterday := weekDays[(int(at.Weekday())+5)%7]
+
+	for _, span := range p.Hours[today] {
+		start, end := parseSpan(span)
+		if end <= start {
+			end = 24 * 60
+		}
+		if minute >= start && minute < end {
+			return true
+		}
+	}
+	for _, span := range p.Hours[yesterday] {
+		if start, end := parseSpan(span); end <= start && minute < end {
+			return true
+		}
+	}
+	return false
+}
+
+// parseSpan returns the start and end minutes of an opening span (HH:MM-HH:MM, see Validate).
+func parseSpan(span string) (int, int) {
+	var startHour, startMinute, endHour, endMinute int
+	fmt.Sscanf(span, "%d:%d-%d:%d", &startHour, &startMinute, &endHour, &endMinute)
+	return startHour*60 + startMinute, endHour*60 + endMinute
+}
+
+// NearbyQuery defines the search of the pizzerias around a place.
+type NearbyQuery struct {
+	Origin    Coordinates
+	RadiusKm  float64
+	Specialty string    // only the pizzerias with this specialty (e.g. "hawaiian"), when not empty
+	OpenNow   bool      // only the pizzerias open at Now
+	Now       time.Time // the time of the open-now filter
+	Limit     int       // maximum number of results (all when 0)
+}
+
+// NearbyPizzeria is a pizzeria found around a place.
+type NearbyPizzeria struct {
+	Pizzeria
+	DistanceKm float64 `json:"distanceKm"`
+	Open       bool    `json:"openNow"`
+}
+
+// Nearby returns the pizzerias within the radius of the origin, sorted by distance,
+// filtered by specialty (case- and accent-insensitive, e.g. "hawaiian" matches "Hawaiian pizza")
+// and by opening hours when OpenNow is set.
+func (d *Directory) Nearby(query NearbyQuery) []NearbyPizzeria {
+	specialty := NormalizeName(query.Specialty)
+	results := []NearbyPizzeria{}
+	for _, pizzeria := range d.Pizzerias {
+		distance := Haversine(query.Origin, pizzeria.Coordinates)
+		if distance > query.RadiusKm {
+			continue
+		}
+		if specialty != "" && !pizzeria.HasSpecialty(specialty) {
+			continue
+		}
+		open := pizzeria.IsOpen(query.Now)
+		if query.OpenNow && !open {
+			continue
+		}
+		results = append(results, NearbyPizzeria{
+			Pizzeria:   pizzeria,
+			DistanceKm: math.Round(distance*100) / 100,
+			Open:       open,
+		})
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].DistanceKm < results[j].DistanceKm
+	})
+	if query.Limit > 0 && len(results) > query.Limit {
+		results = results[:query.Limit]
+	}
+	return results
+}
+
+// HasSpecialty returns whether a specialty of the pizzeria contains the normalized name (see NormalizeName).
+func (p Pizzeria) HasSpecialty(normalized string) bool {
+	for _, specialty := range p.Specialties {
+		if strings.Contains(" "+NormalizeName(specialty)+" ", " "+normalized+" ") {
+			return true
+		}
+	}
+	return false
+}
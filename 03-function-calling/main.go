@@ -5,7 +5,10 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"log/slog"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/openai/openai-go"
 	"github.com/openai/openai-go/option"
@@ -16,172 +19,221 @@ func main() {
 	// Docker Model Runner Chat base URL
 	llmURL := os.Getenv("MODEL_RUNNER_BASE_URL") + "/engines/llama.cpp/v1/"
 	modelTools := os.Getenv("MODEL_RUNNER_LLM_TOOLS")
+	//? with TOOL_CALLING_MODE=prompt, the tools are described in the system prompt,
+	//? for the models whose template doesn't support tools (e.g. ai/llama3.2)
+	toolCallingMode := GetEnv("TOOL_CALLING_MODE", ToolCallingNative)
+	if err := ValidateToolCallingMode(toolCallingMode); err != nil {
+		fmt.Println("😡", err)
+		os.Exit(1)
+	}
 
 	client := openai.NewClient(
 		option.WithBaseURL(llmURL),
 		option.WithAPIKey(""),
 	)
 
-	ctx := context.Background()
-
-	pizzeriaAddresses := openai.ChatCompletionToolParam{
-		Function: openai.FunctionDefinitionParam{
-			Name:        "pizzeria_addresses",
-			Description: openai.String("Give pizzeria addresses in a given city"),
-			Parameters: openai.FunctionParameters{
-				"type": "object",
-				"properties": map[string]interface{}{
-					"city": map[string]string{
-						"type": "string",
-					},
-				},
-				"required": []string{"city"},
-			},
-		},
+	//? the carts of the ordering tools are kept by conversation
+	ctx := WithConversation(context.Background(), GetEnv("CONVERSATION_ID", "demo"))
+
+	//? the tools are registered once, as typed Go functions:
+	//? their JSON Schema is derived from the struct of their arguments
+	//? the pizzerias are loaded from a JSON or CSV file (the embedded data/pizzerias.json by default)
+	directory, err := LoadDirectory(os.Getenv("PIZZERIA_DIRECTORY"))
+	if err != nil {
+		fmt.Println("😡", err)
+		os.Exit(1)
 	}
 
-	sayHelloTool := openai.ChatCompletionToolParam{
-		Function: openai.FunctionDefinitionParam{
-			Name:        "say_hello",
-			Description: openai.String("Say hello to the given person with their first and last name"),
-			Parameters: openai.FunctionParameters{
-				"type": "object",
-				"properties": map[string]interface{}{
-					"firstName": map[string]string{
-						"type": "string",
-					},
-					"lastName": map[string]string{
-						"type": "string",
-					},
-				},
-				"required": []string{"firstName", "lastName"},
-			},
-		},
+	//? the places of the "near me" searches are resolved with a local gazetteer (the embedded data/gazetteer.json by default)
+	gazetteer, err := LoadGazetteer(os.Getenv("GAZETTEER_FILE"))
+	if err != nil {
+		fmt.Println("😡", err)
+		os.Exit(1)
 	}
 
-	tools := []openai.ChatCompletionToolParam{
-		pizzeriaAddresses,
-		sayHelloTool,
+	//? the menu of the ordering tools (the embedded data/menu.json by default)
+	menu, err := LoadMenu(os.Getenv("MENU_FILE"))
+	if err != nil {
+		fmt.Println("😡", err)
+		os.Exit(1)
 	}
 
-	userQuestion := openai.UserMessage(`
+	registry := NewRegistry()
+	//? the invalid arguments are sent back to the model so it can fix them,
+	//? each rejected call is logged as a JSON line on stderr
+	registry.MaxValidationRetries = GetEnvInt("TOOL_VALIDATION_RETRIES", 2)
+	registry.Logger = slog.New(slog.NewJSONHandler(os.Stderr, nil))
+	//? the calls of the tools with side effects (e.g. place_order) need an approval:
+	//? TOOL_APPROVAL=prompt asks the user in the terminal, auto approves them, deny denies them
+	registry.Approve, err = NewApproval(GetEnv("TOOL_APPROVAL", ApprovalPrompt), os.Stdin, os.Stdout)
+	if err != nil {
+		fmt.Println("😡", err)
+		os.Exit(1)
+	}
+	Register(registry, "pizzeria_addresses", "Give pizzeria addresses in a given city, with their coordinates, opening hours and specialties", pizzeriaAddresses(directory))
+	Register(registry, "pizzerias_nearby", "Find the pizzerias near a place (coordinates or address), sorted by distance, optionally with a specialty or open now", pizzeriasNearby(directory, gazetteer))
+	Register(registry, "say_hello", "Say hello to the given person with their first and last name", sayHello)
+	RegisterOrderTools(registry, &Ordering{Menu: menu, Store: NewSessionStore()})
+
+	//? USER_QUESTION replaces the question of the demo, e.g. to take an order:
+	//? "I'd like 2 large Margherita with burrata and a medium Diavola, with the code WELCOME10. My name is Bob, pickup at 19:30."
+	userQuestion := openai.UserMessage(GetEnv("USER_QUESTION", `
 		Give me some pizzeria addresses in Lyon, France.
 		Say Hello to Bob Morane.
 		Give me some pizzeria addresses in Tokyo, Japan.
-	`)
-
-	params := openai.ChatCompletionNewParams{
-		Messages: []openai.ChatCompletionMessageParamUnion{
-			userQuestion,
+		Then sum up the results in a short answer.
+	`))
+
+	agent := Agent{
+		Client: client,
+		Params: openai.ChatCompletionNewParams{
+			ParallelToolCalls: openai.Bool(true),
+			Tools:             registry.Tools(),
+			Model:             modelTools,
+			Temperature:       openai.Opt(0.0),
 		},
-		ParallelToolCalls: openai.Bool(true),
-		Tools:             tools,
-		Model:             modelTools,
-		Temperature:       openai.Opt(0.0),
+		MaxIterations: GetEnvInt("AGENT_MAX_ITERATIONS", 5),
+		Execute:       registry.Execute,
+		Review:        registry.Review,
+		Sequential:    registry.IsSequential,
+		Concurrency:   GetEnvInt("TOOL_CONCURRENCY", 4),
+		ToolTimeout:   time.Duration(GetEnvInt("TOOL_TIMEOUT_SECONDS", 30)) * time.Second,
+		Mode:          toolCallingMode,
+		Output:        os.Stdout,
 	}
 
-	// Make completion request
-	completion, err := client.Chat.Completions.New(ctx, params)
+	// Run the tool-calling loop: the results of the tools are sent back to the model,
+	// until it composes the final answer (streamed to the output)
+	_, _, err = agent.Run(ctx, []openai.ChatCompletionMessageParamUnion{
+		userQuestion,
+	})
+	fmt.Println()
 	if err != nil {
-		panic(err)
-	}
-
-	toolCalls := completion.Choices[0].Message.ToolCalls
-
-	// Return early if there are no tool calls
-	if len(toolCalls) == 0 {
-		fmt.Println("😡 No function call")
-		return
+		fmt.Println("😡", err)
+		os.Exit(1)
 	}
+}
 
-	fmt.Println("🤖 Tool calls:", len(toolCalls))
-	//Display the first tool call
+// SayHelloArgs are the arguments of the say_hello tool.
+type SayHelloArgs struct {
+	FirstName string `json:"firstName" description:"first name of the person" required:"true"`
+	LastName  string `json:"lastName" description:"last name of the person" required:"true"`
+}
 
-	for _, toolCall := range toolCalls {
-		fmt.Println(JSONPretty(toolCall))
-	}
+func sayHello(ctx context.Context, args SayHelloArgs) (string, error) {
+	return fmt.Sprintf("👋 Hello %s %s! 🙂", args.FirstName, args.LastName), nil
+}
 
-	
-	//os.Exit(0)
-	// make the function calls
-	// and display the results
-	for _, toolCall := range toolCalls {
-		fmt.Println("--------------------------------------------")
-		fmt.Println("🤖 Function call:", toolCall.Function.Name)
-		fmt.Println("--------------------------------------------")
-		var args map[string]any
-
-		switch toolCall.Function.Name {
-		case "say_hello":
-			args, _ = JsonStringToMap(toolCall.Function.Arguments)
-			fmt.Println(sayHello(args))
-
-		case "pizzeria_addresses":
-			args, _ = JsonStringToMap(toolCall.Function.Arguments)
-			fmt.Println(pizerriaAddresses(args))
+// PizzeriaAddressesArgs are the arguments of the pizzeria_addresses tool.
+type PizzeriaAddressesArgs struct {
+	City     string `json:"city" description:"name of the city, e.g. Lyon" required:"true"`
+	Page     int    `json:"page" description:"page of the results, starting at 1" minimum:"1"`
+	PageSize int    `json:"pageSize" description:"number of pizzerias by page (5 by default)" minimum:"1" maximum:"20"`
+}
 
-		default:
-			fmt.Println("Unknown function call:", toolCall.Function.Name)
+// pizzeriaAddresses returns the pizzeria_addresses tool, searching the directory by city.
+// The result is a JSON page of pizzerias (see PizzeriaPage).
+func pizzeriaAddresses(directory *Directory) func(ctx context.Context, args PizzeriaAddressesArgs) (string, error) {
+	return func(ctx context.Context, args PizzeriaAddressesArgs) (string, error) {
+		page := directory.FindByCity(args.City, args.Page, args.PageSize)
+		result, err := json.Marshal(page)
+		if err != nil {
+			return "", err
 		}
+		return string(result), nil
 	}
-
 }
 
-func sayHello(arguments map[string]interface{}) string {
-
-	firstName, ok1 := arguments["firstName"].(string)
-	lastName, ok2 := arguments["lastName"].(string)
-	if !ok1 || !ok2 {
-		return "Invalid arguments for say_hello function"
-	}
-	return fmt.Sprintf("👋 Hello %s %s! 🙂", firstName, lastName)
-
+// PizzeriasNearbyArgs are the arguments of the pizzerias_nearby tool.
+type PizzeriasNearbyArgs struct {
+	Latitude  *float64 `json:"latitude" description:"latitude of the place, in degrees" minimum:"-90" maximum:"90"`
+	Longitude *float64 `json:"longitude" description:"longitude of the place, in degrees" minimum:"-180" maximum:"180"`
+	Address   string   `json:"address" description:"address or name of the place (when there are no coordinates), e.g. Place Bellecour, Lyon"`
+	RadiusKm  *float64 `json:"radiusKm" description:"search radius in kilometers (2 by default)" minimum:"0.1" maximum:"50"`
+	Specialty string   `json:"specialty" description:"only the pizzerias serving this specialty, e.g. Hawaiian"`
+	OpenNow   bool     `json:"openNow" description:"only the pizzerias open now"`
+	Limit     *int     `json:"limit" description:"maximum number of pizzerias (5 by default)" minimum:"1" maximum:"20"`
 }
 
-func pizerriaAddresses(arguments map[string]interface{}) string {
-	city, ok := arguments["city"].(string)
-	if !ok {
-		return "Invalid arguments for pizzeria_addresses function"
-	}
-
-	addresses := map[string][]string{
-		"Lyon": {
-			"123 Pizza St, Lyon, France",
-			"456 Pizzeria Ave, Lyon, France",
-			"789 Italian Bistro, Lyon, France",
-		},
-		"Tokyo": {
-			"123 Sushi St, Tokyo, Japan",
-			"456 Ramen Ave, Tokyo, Japan",
-			"789 Izakaya Bistro, Tokyo, Japan",
-		},
-	}
-	if addresses, ok := addresses[city]; ok {
-		return fmt.Sprintf("🍕 Pizzerias in %s: \n1. %s\n2. %s\n3. %s", city, addresses[0], addresses[1], addresses[2])
-	}
-	// If city not found, return a default message
-	// You can also return an empty list or a message indicating no pizzerias found
-	// depending on your requirements.
-	// For now, let's return a default message
-	return fmt.Sprintf("🍕 No Pizzerias in %s:", city)
-
-}
+// pizzeriasNearby returns the pizzerias_nearby tool, searching the directory around a place.
+// The result is a JSON object with the resolved place and the pizzerias sorted by distance.
+func pizzeriasNearby(directory *Directory, gazetteer *Gazetteer) func(ctx context.Context, args PizzeriasNearbyArgs) (string, error) {
+	return func(ctx context.Context, args PizzeriasNearbyArgs) (string, error) {
+		var origin Coordinates
+		place := ""
+		switch {
+		case args.Latitude != nil && args.Longitude != nil:
+			origin = Coordinates{Latitude: *args.Latitude, Longitude: *args.Longitude}
+		case args.Address != "":
+			resolved, ok := gazetteer.Resolve(args.Address)
+			if !ok {
+				return "", fmt.Errorf("unknown place %q, give its coordinates (latitude and longitude)", args.Address)
+			}
+			origin, place = resolved.Coordinates, resolved.Name
+		default:
+			return "", fmt.Errorf("give the latitude and the longitude, or the address of the place")
+		}
+		// the optional arguments are pointers: nil (not given) means the default
+		radiusKm, limit := 2.0, 5
+		if args.RadiusKm != nil {
+			radiusKm = *args.RadiusKm
+		}
+		if args.Limit != nil {
+			limit = *args.Limit
+		}
 
-func JsonStringToMap(jsonString string) (map[string]interface{}, error) {
-	var result map[string]interface{}
-	err := json.Unmarshal([]byte(jsonString), &result)
-	if err != nil {
-		return nil, err
+		pizzerias := directory.Nearby(NearbyQuery{
+			Origin:    origin,
+			RadiusKm:  radiusKm,
+			Specialty: args.Specialty,
+			OpenNow:   args.OpenNow,
+			Now:       time.Now(),
+			Limit:     limit,
+		})
+		result, err := json.Marshal(map[string]any{
+			"place":     place,
+			"origin":    origin,
+			"radiusKm":  radiusKm,
+			"pizzerias": pizzerias,
+		})
+		if err != nil {
+			return "", err
+		}
+		return string(result), nil
 	}
-	return result, nil
 }
 
 func JSONPretty(toolCall openai.ChatCompletionMessageToolCall) string {
+	raw := toolCall.RawJSON()
+	if raw == "" {
+		// the tool call was not unmarshaled from a response (e.g. accumulated from a stream)
+		data, _ := json.Marshal(toolCall)
+		raw = string(data)
+	}
 	// how to pretty print a json string
 	var prettyJSON bytes.Buffer
-	_ = json.Indent(&prettyJSON, []byte(toolCall.RawJSON()), "", "\t")
+	_ = json.Indent(&prettyJSON, []byte(raw), "", "\t")
 	// and remove escape characters
 	prettyJSONString := prettyJSON.String()
 	prettyJSONString = string(bytes.ReplaceAll([]byte(prettyJSONString), []byte("\\\""), []byte("\"")))
 	return prettyJSONString
 }
+
+// GetEnv returns the value of an environment variable,
+// or the default value if the variable is not set.
+func GetEnv(name, defaultValue string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+// GetEnvInt returns the value of an integer environment variable,
+// or the default value if the variable is not set or invalid.
+func GetEnvInt(name string, defaultValue int) int {
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+//go:embed data/menu.json
+var defaultMenu []byte
+
+// Pizza sizes of the menu.
+var pizzaSizes = []string{"small", "medium", "large"}
+
+// MenuPizza is a pizza of the menu, with its price by size.
+type MenuPizza struct {
+	Name        string             `json:"name"`
+	Description string             `json:"description"`
+	Prices      map[string]float64 `json:"prices"` // by size (small, medium, large)
+}
+
+// Topping is an extra topping of the menu.
+type Topping struct {
+	Name  string  `json:"name"`
+	Price float64 `json:"price"`
+}
+
+// PromoCode is a discount on the subtotal of the cart: a percentage or an amount,
+// from a minimum subtotal.
+type PromoCode struct {
+	Code        string  `json:"code"`
+	Description string  `json:"description"`
+	Percent     float64 `json:"percent,omitempty"`
+	Amount      float64 `json:"amount,omitempty"`
+	MinSubtotal float64 `json:"minSubtotal,omitempty"`
+}
+
+// Menu is the menu of the pizzeria: the pizzas, the toppings, the promo codes and the tax rate.
+type Menu struct {
+	Currency   string      `json:"currency"`
+	TaxRate    float64     `json:"taxRate"` // e.g. 0.10 for 10%
+	Pizzas     []MenuPizza `json:"pizzas"`
+	Toppings   []Topping   `json:"toppings"`
+	PromoCodes []PromoCode `json:"promoCodes"`
+}
+
+// LoadMenu loads the menu from a JSON file.
+// With an empty path, the menu embedded in the program (data/menu.json) is used.
+func LoadMenu(path string) (*Menu, error) {
+	data := defaultMenu
+	if path != "" {
+		var err error
+		if data, err = os.ReadFile(path); err != nil {
+			return nil, fmt.Errorf("loading the menu %s: %w", path, err)
+		}
+	}
+	var menu Menu
+	if err := json.Unmarshal(data, &menu); err != nil {
+		return nil, fmt.Errorf("loading the menu %s: %w", path, err)
+	}
+	for _, pizza := range menu.Pizzas {
+		for size := range pizza.Prices {
+			if !slices.Contains(pizzaSizes, size) {
+				return nil, fmt.Errorf("invalid size %q of the pizza %s (expected %s)", size, pizza.Name, strings.Join(pizzaSizes, ", "))
+			}
+		}
+	}
+	return &menu, nil
+}
+
+// Pizza returns the pizza of the menu with the given name (case- and accent-insensitive).
+func (m *Menu) Pizza(name string) (MenuPizza, bool) {
+	for _, pizza := range m.Pizzas {
+		if NormalizeName(pizza.Name) == NormalizeName(name) {
+			return pizza, true
+		}
+	}
+	return MenuPizza{}, false
+}
+
+// Topping returns the topping of the menu with the given name (case- and accent-insensitive).
+func (m *Menu) Topping(name string) (Topping, bool) {
+	for _, topping := range m.Toppings {
+		if NormalizeName(topping.Name) == NormalizeName(name) {
+			return topping, true
+		}
+	}
+	return Topping{}, false
+}
+
+// PromoCode returns the promo code of the menu (case-insensitive).
+func (m *Menu) PromoCode(code string) (PromoCode, bool) {
+	for _, promo := range m.PromoCodes {
+		if strings.EqualFold(promo.Code, strings.TrimSpace(code)) {
+			return promo, true
+		}
+	}
+	return PromoCode{}, false
+}
+
+// CartItem is a line of the cart.
+type CartItem struct {
+	ID        int      `json:"id"`
+	Pizza     string   `json:"pizza"`
+	Size      string   `json:"size"`
+	Toppings  []string `json:"toppings,omitempty"`
+	Quantity  int      `json:"quantity"`
+	UnitPrice float64  `json:"unitPrice"` // the price of the pizza with its toppings
+}
+
+// Totals are the amounts of a cart: the discount applies to the subtotal, the tax to the discounted subtotal.
+type Totals struct {
+	Subtotal float64 `json:"subtotal"`
+	Discount float64 `json:"discount"`
+	Tax      float64 `json:"tax"`
+	Total    float64 `json:"total"`
+	Currency string  `json:"currency"`
+}
+
+// Cart is the cart of a conversation.
+type Cart struct {
+	Items     []CartItem `json:"items"`
+	PromoCode string     `json:"promoCode,omitempty"`
+	Totals    Totals     `json:"totals"`
+
+	nextID int
+}
+
+// Order is a placed order.
+type Order struct {
+	ID           string     `json:"id"`
+	CustomerName string     `json:"customerName"`
+	PickupTime   string     `json:"pickupTime,omitempty"`
+	Items        []CartItem `json:"items"`
+	PromoCode    string     `json:"promoCode,omitempty"`
+	Totals       Totals     `json:"totals"`
+	PlacedAt     time.Time  `json:"placedAt"`
+}
+
+// cents converts an amount to cents, so the totals are computed without rounding errors.
+func cents(amount float64) int64 {
+	return int64(math.Round(amount * 100))
+}
+
+// ComputeTotals computes the totals of the cart with the promo code and the tax rate of the menu.
+func (m *Menu) ComputeTotals(cart *Cart) Totals {
+	var subtotal int64
+	for _, item := range cart.Items {
+		subtotal += cents(item.UnitPrice) * int64(item.Quantity)
+	}
+	var discount int64
+	if promo, ok := m.PromoCode(cart.PromoCode); ok && subtotal >= cents(promo.MinSubtotal) {
+		discount = cents(promo.Amount) + int64(math.Round(float64(subtotal)*promo.Percent/100))
+		discount = min(discount, subtotal)
+	}
+	tax := int64(math.Round(float64(subtotal-discount) * m.TaxRate))
+	return Totals{
+		Subtotal: float64(subtotal) / 100,
+		Discount: float64(discount) / 100,
+		Tax:      float64(tax) / 100,
+		Total:    float64(subtotal-discount+tax) / 100,
+		Currency: m.Currency,
+	}
+}
+
+// SessionStore keeps the cart and the orders of each conversation (in memory).
+type SessionStore struct {
+	mutex  sync.Mutex
+	carts  map[string]*Cart
+	orders map[string][]Order
+}
+
+// NewSessionStore creates an empty session store.
+func NewSessionStore() *SessionStore {
+	return &SessionStore{carts: map[string]*Cart{}, orders: map[string][]Order{}}
+}
+
+type conversationKey struct{}
+
+// WithConversation returns a context carrying the ID of the conversation,
+// used by the ordering tools to find the cart of the conversation.
+func WithConversation(ctx context.Context, conversationID string) context.Context {
+	return context.WithValue(ctx, conversationKey{}, conversationID)
+}
+
+// ConversationID returns the ID of the conversation of the context ("default" when there is none).
+func ConversationID(ctx context.Context) string {
+	if id, ok := ctx.Value(conversationKey{}).(string); ok && id != "" {
+		return id
+	}
+	return "default"
+}
+
+// Update runs the function with the cart of the conversation, under the lock of the store
+// (the cart tools of a turn are sequential, but other conversations can use the store at the same time).
+func (s *SessionStore) Update(ctx context.Context, fn func(cart *Cart) error) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	id := ConversationID(ctx)
+	cart, ok := s.carts[id]
+	if !ok {
+		cart = &Cart{Items: []CartItem{}, nextID: 1}
+		s.carts[id] = cart
+	}
+	return fn(cart)
+}
+
+// Orders returns the orders placed in the conversation.
+func (s *SessionStore) Orders(ctx context.Context) []Order {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.orders[ConversationID(ctx)]
+}
+
+// Ordering is the ordering domain: the menu and the carts of the conversations.
+type Ordering struct {
+	Menu  *Menu
+	Store *SessionStore
+}
+
+// RegisterOrderTools registers the ordering tools: get_menu, add_to_cart, remove_from_cart,
+// apply_promo_code, view_cart and place_order (the only one with side effects: it needs an approval).
+// The cart tools are sequential: the calls of a turn change the cart in the order of the calls.
+func RegisterOrderTools(registry *Registry, ordering *Ordering) {
+	Register(registry, "get_menu", "Get the menu: the pizzas with their prices by size, the extra toppings and the currency", ordering.GetMenu)
+	Register(registry, "add_to_cart", "Add pizzas to the cart, with a size and extra toppings", ordering.AddToCart, Sequential())
+	Register(registry, "remove_from_cart", "Remove an item of the cart (or some of its quantity)", ordering.RemoveFromCart, Sequential())
+	Register(registry, "apply_promo_code", "Apply a promo code to the cart", ordering.ApplyPromoCode, Sequential())
+	Register(registry, "view_cart", "Show the cart with the subtotal, the discount, the tax and the total", ordering.ViewCart, Sequential())
+	Register(registry, "place_order", "Place the order of the cart for the customer", ordering.PlaceOrder, WithSideEffects(), Sequential())
+}
+
+// GetMenuArgs are the arguments of the get_menu tool.
+type GetMenuArgs struct{}
+
+// GetMenu returns the menu, without the promo codes.
+func (o *Ordering) GetMenu(ctx context.Context, args GetMenuArgs) (string, error) {
+	return toJSON(map[string]any{
+		"currency": o.Menu.Currency,
+		"pizzas":   o.Menu.Pizzas,
+		"toppings": o.Menu.Toppings,
+	})
+}
+
+// AddToCartArgs are the arguments of the add_to_cart tool.
+type AddToCartArgs struct {
+	Pizza    string   `json:"pizza" description:"name of the pizza of the menu, e.g. Margherita" required:"true"`
+	Size     string   `json:"size" description:"size of the pizza" enum:"small,medium,large" required:"true"`
+	Toppings []string `json:"toppings" description:"extra toppings of the menu"`
+	Quantity int      `json:"quantity" description:"number of pizzas (1 by default)" minimum:"1" maximum:"20"`
+}
+
+// AddToCart adds an item to the cart of the conversation, and returns the cart.
+func (o *Ordering) AddToCart(ctx context.Context, args AddToCartArgs) (string, error) {
+	pizza, ok := o.Menu.Pizza(args.Pizza)
+	if !ok {
+		return "", fmt.Errorf("%s is not on the menu", args.Pizza)
+	}
+	price, ok := pizza.Prices[args.Size]
+	if !ok {
+		return "", fmt.Errorf("the %s pizza doesn't exist in %s size", pizza.Name, args.Size)
+	}
+	toppings := []string{}
+	for _, name := range args.Toppings {
+		topping, ok := o.Menu.Topping(name)
+		if !ok {
+			return "", fmt.Errorf("the topping %s is not on the menu", name)
+		}
+		toppings = append(toppings, topping.Name)
+		price += topping.Price
+	}
+	if args.Quantity == 0 {
+		args.Quantity = 1
+	}
+
+	return o.updateCart(ctx, func(cart *Cart) error {
+		cart.Items = append(cart.Items, CartItem{
+			ID:        cart.nextID,
+			Pizza:     pizza.Name,
+			Size:      args.Size,
+			Toppings:  toppings,
+			Quantity:  args.Quantity,
+			UnitPrice: float64(cents(price)) / 100,
+		})
+		cart.nextID++
+		return nil
+	})
+}
+
+// RemoveFromCartArgs are the arguments of the remove_from_cart tool.
+type RemoveFromCartArgs struct {
+	ItemID   int `json:"itemId" description:"id of the item of the cart" required:"true" minimum:"1"`
+	Quantity int `json:"quantity" description:"number of pizzas to remove (the whole item by default)" minimum:"1"`
+}
+
+// RemoveFromCart removes an item of the cart (or some of its quantity), and returns the cart.
+func (o *Ordering) RemoveFromCart(ctx context.Context, args RemoveFromCartArgs) (string, error) {
+	return o.updateCart(ctx, func(cart *Cart) error {
+		for idx, item := range cart.Items {
+			if item.ID != args.ItemID {
+				continue
+			}
+			if args.Quantity > 0 && args.Quantity < item.Quantity {
+				cart.Items[idx].Quantity -= args.Quantity
+			} else {
+				cart.Items = append(cart.Items[:idx], cart.Items[idx+1:]...)
+			}
+			return nil
+		}
+		return fmt.Errorf("there is no item %d in the cart", args.ItemID)
+	})
+}
+
+// ApplyPromoCodeArgs are the arguments of the apply_promo_code tool.
+type ApplyPromoCodeArgs struct {
+	Code string `json:"code" description:"the promo code, e.g. WELCOME10" required:"true"`
+}
+
+// ApplyPromoCode applies a promo code to the cart, and returns the cart.
+func (o *Ordering) ApplyPromoCode(ctx context.Context, args ApplyPromoCodeArgs) (string, error) {
+	promo, ok := o.Menu.PromoCode(args.Code)
+	if !ok {
+		return "", fmt.Errorf("the promo code %s doesn't exist", args.Code)
+	}
+	return o.updateCart(ctx, func(cart *Cart) error {
+		if subtotal := o.Menu.ComputeTotals(cart).Subtotal; cents(subtotal) < cents(promo.MinSubtotal) {
+			return fmt.Errorf("the promo code %s needs a subtotal of at least %.2f %s (the subtotal is %.2f)",
+				promo.Code, promo.MinSubtotal, o.Menu.Currency, subtotal)
+		}
+		cart.PromoCode = promo.Code
+		return nil
+	})
+}
+
+// ViewCartArgs are the arguments of the view_cart tool.
+type ViewCartArgs struct{}
+
+// ViewCart returns the cart of the conversation with its totals.
+func (o *Ordering) ViewCart(ctx context.Context, args ViewCartArgs) (string, error) {
+	return o.updateCart(ctx, func(cart *Cart) error { return nil })
+}
+
+// PlaceOrderArgs are the arguments of the place_order tool.
+type PlaceOrderArgs struct {
+	CustomerName string `json:"customerName" description:"name of the customer" required:"true"`
+	PickupTime   string `json:"pickupTime" description:"pickup time, HH:MM" format:"time"`
+}
+
+// PlaceOrder places the order of the cart of the conversation, empties the cart, and returns the order.
+func (o *Ordering) PlaceOrder(ctx context.Context, args PlaceOrderArgs) (string, error) {
+	var order Order
+	err := o.Store.Update(ctx, func(cart *Cart) error {
+		if len(cart.Items) == 0 {
+			return fmt.Errorf("the cart is empty")
+		}
+		order = Order{
+			ID:           fmt.Sprintf("%s-%d", ConversationID(ctx), len(o.Store.orders[ConversationID(ctx)])+1),
+			CustomerName: args.CustomerName,
+			PickupTime:   args.PickupTime,
+			Items:        cart.Items,
+			PromoCode:    cart.PromoCode,
+			Totals:       o.Menu.ComputeTotals(cart),
+			PlacedAt:     time.Now(),
+		}
+		o.Store.orders[ConversationID(ctx)] = append(o.Store.orders[ConversationID(ctx)], order)
+		*cart = Cart{Items: []CartItem{}, nextID: cart.nextID}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return toJSON(order)
+}
+
+// updateCart updates the cart of the conversation, computes its totals, and returns it as JSON.
+func (o *Ordering) updateCart(ctx context.Context, fn func(cart *Cart) error) (string, error) {
+	var result string
+	err := o.Store.Update(ctx, func(cart *Cart) error {
+		if err := fn(cart); err != nil {
+			return err
+		}
+		cart.Totals = o.Menu.ComputeTotals(cart)
+		var err error
+		result, err = toJSON(cart)
+		return err
+	})
+	return result, err
+}
+
+// toJSON returns the JSON result of a tool.
+func toJSON(value any) (string, error) {
+	result, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return string(result), nil
+}
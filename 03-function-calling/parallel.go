@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/openai/openai-go"
+)
+
+// ExecuteToolCalls executes the tool calls of a model turn concurrently:
+//   - at most `concurrency` tool calls run at the same time (1 or less: one by one),
+//   - the tool calls matching `sequential` (nil: none, see Sequential) run one at a time, in the order of the calls,
+//     while the other tool calls run concurrently,
+//   - each tool call gets its own context with the timeout (0: no timeout),
+//     a tool call still running after the timeout gets an error result
+//     (the tool keeps its place in the concurrency limit until it returns,
+//     and the sequential tool calls after it are not executed),
+//   - a panic of a tool is converted to an error result.
+//
+// It returns the results in the order of the tool calls.
+func ExecuteToolCalls(ctx context.Context, toolCalls []openai.ChatCompletionMessageToolCall, concurrency int, timeout time.Duration, execute ToolExecutor, sequential ToolFilter) []string {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	results := make([]string, len(toolCalls))
+	semaphore := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+
+	// run executes a tool call, and reports whether the tool returned before the timeout
+	run := func(idx int) bool {
+		semaphore <- struct{}{}
+		// the place is released when the tool returns, which can be after the timeout
+		result, timedOut := executeToolCall(ctx, toolCalls[idx], timeout, execute, func() { <-semaphore })
+		results[idx] = result
+		return !timedOut
+	}
+
+	chain := []int{}
+	for idx, toolCall := range toolCalls {
+		if sequential != nil && sequential(toolCall) {
+			chain = append(chain, idx)
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			run(idx)
+		}()
+	}
+	// the sequential tool calls run in a single goroutine, so each one sees the changes of the previous ones:
+	// when a tool is still running after the timeout, its changes are unknown, so the chain stops
+	if len(chain) > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for pos, idx := range chain {
+				if run(idx) {
+					continue
+				}
+				for _, next := range chain[pos+1:] {
+					results[next] = fmt.Sprintf("Error: %s: not executed: the previous call of %s did not finish in time",
+						toolCalls[next].Function.Name, toolCalls[idx].Function.Name)
+				}
+				return
+			}
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// executeToolCall executes a tool call with a timeout, and recovers from the panics of the tool.
+// It returns the result, and whether the tool was still running at the timeout:
+// `finished` is called when the tool returns, which can be after the timeout.
+func executeToolCall(ctx context.Context, toolCall openai.ChatCompletionMessageToolCall, timeout time.Duration, execute ToolExecutor, finished func()) (string, bool) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	// the tool runs in its own goroutine, so a tool ignoring the context doesn't block the turn
+	// (the buffered channel lets it finish after the timeout)
+	done := make(chan string, 1)
+	go func() {
+		defer finished()
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				done <- fmt.Sprintf("Error: %s: the tool panicked: %v", toolCall.Function.Name, recovered)
+			}
+		}()
+		done <- execute(ctx, toolCall)
+	}()
+
+	select {
+	case result := <-done:
+		return result, false
+	case <-ctx.Done():
+		return fmt.Sprintf("Error: %s: %v", toolCall.Function.Name, ctx.Err()), true
+	}
+}
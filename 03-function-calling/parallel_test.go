@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/openai/openai-go"
+)
+
+func testToolCalls(names ...string) []openai.ChatCompletionMessageToolCall {
+	toolCalls := make([]openai.ChatCompletionMessageToolCall, len(names))
+	for idx, name := range names {
+		toolCalls[idx] = openai.ChatCompletionMessageToolCall{ID: name, Function: openai.ChatCompletionMessageToolCallFunction{Name: name}}
+	}
+	return toolCalls
+}
+
+// isCart makes the tool calls named cart* sequential
+func isCart(toolCall openai.ChatCompletionMessageToolCall) bool {
+	return strings.HasPrefix(toolCall.Function.Name, "cart")
+}
+
+func TestExecuteToolCallsSequentialOrder(t *testing.T) {
+	var mutex sync.Mutex
+	started := []string{}
+	running := 0 // sequential tool calls running
+	execute := func(ctx context.Context, toolCall openai.ChatCompletionMessageToolCall) string {
+		name := toolCall.Function.Name
+		mutex.Lock()
+		started = append(started, name)
+		if isCart(toolCall) {
+			running++
+			if running > 1 {
+				t.Errorf("%s runs alongside another sequential tool call", name)
+			}
+		}
+		mutex.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mutex.Lock()
+		if isCart(toolCall) {
+			running--
+		}
+		mutex.Unlock()
+		return "result of " + name
+	}
+
+	toolCalls := testToolCalls("cart1", "menu1", "cart2", "menu2", "cart3", "menu3")
+	results := ExecuteToolCalls(context.Background(), toolCalls, 4, time.Second, execute, isCart)
+
+	for idx, toolCall := range toolCalls {
+		if want := "result of " + toolCall.Function.Name; results[idx] != want {
+			t.Errorf("result #%d: expected %q, got %q", idx, want, results[idx])
+		}
+	}
+	carts := slices.DeleteFunc(slices.Clone(started), func(name string) bool { return !strings.HasPrefix(name, "cart") })
+	if !slices.Equal(carts, []string{"cart1", "cart2", "cart3"}) {
+		t.Errorf("the sequential tool calls started in the order %v", carts)
+	}
+}
+
+func TestExecuteToolCallsTimeout(t *testing.T) {
+	release := make(chan struct{})
+	var mutex sync.Mutex
+	executed := []string{}
+	// the slow tools ignore the context, and return when they are released
+	execute := func(ctx context.Context, toolCall openai.ChatCompletionMessageToolCall) string {
+		name := toolCall.Function.Name
+		mutex.Lock()
+		executed = append(executed, name)
+		mutex.Unlock()
+		if strings.HasSuffix(name, "slow") {
+			<-release
+		}
+		if name == "panic" {
+			panic("boom")
+		}
+		return "result of " + name
+	}
+
+	toolCalls := testToolCalls("cart1", "cart2-slow", "cart3", "menu", "panic", "cart4")
+	start := time.Now()
+	results := ExecuteToolCalls(context.Background(), toolCalls, 4, 50*time.Millisecond, execute, isCart)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("the timed-out tool blocked the turn for %s", elapsed)
+	}
+
+	want := []string{
+		"result of cart1",
+		"Error: cart2-slow: context deadline exceeded",
+		"Error: cart3: not executed: the previous call of cart2-slow did not finish in time",
+		"result of menu",
+		"Error: panic: the tool panicked: boom",
+		"Error: cart4: not executed: the previous call of cart2-slow did not finish in time",
+	}
+	if !slices.Equal(results, want) {
+		t.Errorf("unexpected results:\n%q\nexpected:\n%q", results, want)
+	}
+	close(release)
+	mutex.Lock()
+	defer mutex.Unlock()
+	if slices.Contains(executed, "cart3") || slices.Contains(executed, "cart4") {
+		t.Errorf("the sequential tool calls after the timeout were executed: %v", executed)
+	}
+}
+
+func TestExecuteToolCallsConcurrencyAfterTimeout(t *testing.T) {
+	var mutex sync.Mutex
+	running, maxRunning := 0, 0
+	// the tools ignore the context, and run after the timeout
+	execute := func(ctx context.Context, toolCall openai.ChatCompletionMessageToolCall) string {
+		mutex.Lock()
+		running++
+		maxRunning = max(maxRunning, running)
+		mutex.Unlock()
+		time.Sleep(30 * time.Millisecond)
+		mutex.Lock()
+		running--
+		mutex.Unlock()
+		return "result of " + toolCall.Function.Name
+	}
+
+	// one by one: each tool call waits for the timed-out tool to return
+	results := ExecuteToolCalls(context.Background(), testToolCalls("menu1", "menu2", "menu3"), 1, 5*time.Millisecond, execute, nil)
+	want := []string{
+		"Error: menu1: context deadline exceeded",
+		"Error: menu2: context deadline exceeded",
+		"Error: menu3: context deadline exceeded",
+	}
+	if !slices.Equal(results, want) {
+		t.Errorf("unexpected results:\n%q\nexpected:\n%q", results, want)
+	}
+	time.Sleep(50 * time.Millisecond) // the last tool returns after the results
+	mutex.Lock()
+	defer mutex.Unlock()
+	if maxRunning != 1 {
+		t.Errorf("expected the tool calls to run one by one, got %d at the same time", maxRunning)
+	}
+}
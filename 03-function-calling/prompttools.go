@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/openai/openai-go"
+)
+
+// Tool calling modes:
+//   - native: the tools are sent with the request, the chat template of the model must support them,
+//   - prompt: the tools are described in the system prompt, and the model answers with a JSON envelope
+//     parsed from its text (for the models without native tool support, e.g. llama3.2).
+const (
+	ToolCallingNative = "native"
+	ToolCallingPrompt = "prompt"
+)
+
+// ValidateToolCallingMode checks the tool calling mode.
+func ValidateToolCallingMode(mode string) error {
+	switch mode {
+	case ToolCallingNative, ToolCallingPrompt:
+		return nil
+	default:
+		return fmt.Errorf("unknown tool calling mode %q (expected %s or %s)", mode, ToolCallingNative, ToolCallingPrompt)
+	}
+}
+
+// PromptToolsInstructions returns the system instructions describing the tools,
+// and the strict JSON envelope of the tool calls:
+//
+//	{"tool_calls": [{"name": "say_hello", "arguments": {"firstName": "Bob", "lastName": "Morane"}}]}
+func PromptToolsInstructions(tools []openai.ChatCompletionToolParam) string {
+	var instructions strings.Builder
+	instructions.WriteString("You can use the following tools:\n\n")
+	for _, tool := range tools {
+		parameters, _ := json.Marshal(tool.Function.Parameters)
+		fmt.Fprintf(&instructions, "- name: %s\n  description: %s\n  parameters (JSON Schema): %s\n",
+			tool.Function.Name, tool.Function.Description.Value, parameters)
+	}
+	instructions.WriteString(`
+To use tools, answer ONLY with this JSON object, without any other text:
+{"tool_calls": [{"name": "<tool name>", "arguments": {<arguments matching the parameters>}}]}
+You can call several tools at once by adding several objects to "tool_calls".
+The results of the tools will be sent to you in a message starting with TOOL RESULTS.
+When you don't need any tool (or when you have the results), answer the user in plain text, without JSON.
+`)
+	return instructions.String()
+}
+
+// promptToolCall is a tool call of the JSON envelope.
+type promptToolCall struct {
+	Name      string          `json:"name"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+// ParseToolCalls extracts the tool calls of the JSON envelope from the text of the model.
+// The envelope can be in a code fence and surrounded by prose; a single
+// {"name": ..., "arguments": ...} object is accepted too, when it has the "arguments" key
+// and names one of the tools (the answers can quote JSON, e.g. a pizzeria with a "name").
+// It returns nil when the text contains no tool call (it is a plain answer),
+// and an error when the envelope is invalid or calls an unknown tool.
+//
+// The tool calls have the same structure as the ones of the native path:
+// their IDs are call_0, call_1... in the order of the envelope.
+func ParseToolCalls(text string, tools []openai.ChatCompletionToolParam) ([]openai.ChatCompletionMessageToolCall, error) {
+	text = strings.ReplaceAll(text, "```json", "```")
+	text = strings.ReplaceAll(text, "```", "\n")
+
+	known := map[string]bool{}
+	for _, tool := range tools {
+		known[tool.Function.Name] = true
+	}
+
+	var lastErr error
+	for start := strings.IndexByte(text, '{'); start >= 0; {
+		// the decoder stops at the end of the first JSON value: the trailing prose is ignored
+		var envelope struct {
+			ToolCalls []promptToolCall `json:"tool_calls"`
+			promptToolCall
+		}
+		err := json.NewDecoder(strings.NewReader(text[start:])).Decode(&envelope)
+		switch {
+		case err != nil:
+			lastErr = err
+		case len(envelope.ToolCalls) > 0:
+			for _, call := range envelope.ToolCalls {
+				if call.Name != "" && !known[call.Name] {
+					return nil, fmt.Errorf("unknown tool %q in the tool calls envelope", call.Name)
+				}
+			}
+			return newToolCalls(envelope.ToolCalls)
+		case envelope.Arguments != nil && known[envelope.Name]:
+			return newToolCalls([]promptToolCall{envelope.promptToolCall})
+		}
+		next := strings.IndexByte(text[start+1:], '{')
+		if next < 0 {
+			break
+		}
+		start += next + 1
+	}
+	if lastErr != nil && strings.Contains(text, `"tool_calls"`) {
+		return nil, fmt.Errorf("invalid tool calls envelope: %w", lastErr)
+	}
+	return nil, nil
+}
+
+// newToolCalls converts the tool calls of the envelope to the tool calls of the SDK.
+// They are unmarshaled from JSON, like the ones of the native path (so RawJSON works).
+func newToolCalls(calls []promptToolCall) ([]openai.ChatCompletionMessageToolCall, error) {
+	toolCalls := []openai.ChatCompletionMessageToolCall{}
+	for idx, call := range calls {
+		if call.Name == "" {
+			return nil, errors.New("a tool call of the envelope has no name")
+		}
+		arguments := bytes.TrimSpace(call.Arguments)
+		if len(arguments) == 0 || string(arguments) == "null" {
+			arguments = []byte("{}")
+		}
+		raw, err := json.Marshal(map[string]any{
+			"id":   fmt.Sprintf("call_%d", idx),
+			"type": "function",
+			"function": map[string]string{
+				"name":      call.Name,
+				"arguments": string(arguments),
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		var toolCall openai.ChatCompletionMessageToolCall
+		if err := json.Unmarshal(raw, &toolCall); err != nil {
+			return nil, err
+		}
+		toolCalls = append(toolCalls, toolCall)
+	}
+	return toolCalls, nil
+}
+
+// PromptToolResults returns the message sending the results of the tool calls back to the model
+// (in prompt mode, the model doesn't know the tool messages).
+func PromptToolResults(toolCalls []openai.ChatCompletionMessageToolCall, results []string) string {
+	var message strings.Builder
+	message.WriteString("TOOL RESULTS:\n")
+	for idx, toolCall := range toolCalls {
+		fmt.Fprintf(&message, "\n- %s(%s):\n%s\n", toolCall.Function.Name, toolCall.Function.Arguments, results[idx])
+	}
+	message.WriteString("\nUse these results to answer, or call other tools with the JSON envelope.")
+	return message.String()
+}
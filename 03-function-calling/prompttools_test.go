@@ -0,0 +1,90 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/openai/openai-go"
+)
+
+func TestParseToolCalls(t *testing.T) {
+	tools := []openai.ChatCompletionToolParam{
+		{Function: openai.FunctionDefinitionParam{Name: "pizzeria_addresses"}},
+		{Function: openai.FunctionDefinitionParam{Name: "say_hello"}},
+	}
+
+	tests := []struct {
+		name    string
+		text    string
+		calls   []string // the expected names of the tool calls
+		wantErr bool
+	}{
+		{
+			name: "plain answer",
+			text: "Hawaiian pizza was created in Canada in 1962.",
+		},
+		{
+			name:  "envelope",
+			text:  `{"tool_calls": [{"name": "say_hello", "arguments": {"firstName": "Bob", "lastName": "Morane"}}]}`,
+			calls: []string{"say_hello"},
+		},
+		{
+			name:  "envelope in a code fence with prose",
+			text:  "Sure, let me look.\n```json\n{\"tool_calls\": [{\"name\": \"pizzeria_addresses\", \"arguments\": {\"city\": \"Lyon\"}}, {\"name\": \"say_hello\", \"arguments\": {\"firstName\": \"Jane\"}}]}\n```\nOne moment.",
+			calls: []string{"pizzeria_addresses", "say_hello"},
+		},
+		{
+			name:  "single call",
+			text:  `{"name": "pizzeria_addresses", "arguments": {"city": "Naples"}}`,
+			calls: []string{"pizzeria_addresses"},
+		},
+		{
+			name: "prose quoting a pizzeria of the results",
+			text: "Here is the best one: {\"name\": \"Pizzeria Da Michele\", \"address\": \"Via Cesare Sersale 1\", \"city\": \"Naples\"}. Enjoy!",
+		},
+		{
+			name: "prose quoting a page of the results",
+			text: "I found:\n```json\n{\"query\": \"Naples\", \"city\": \"Naples\", \"total\": 1, \"pizzerias\": [{\"name\": \"Pizzeria Da Michele\", \"city\": \"Naples\"}]}\n```",
+		},
+		{
+			name: "tool name without arguments",
+			text: `The tool {"name": "say_hello"} greets people.`,
+		},
+		{
+			name: "unknown tool with arguments",
+			text: `{"name": "Pizzeria Da Michele", "arguments": {"city": "Naples"}}`,
+		},
+		{
+			name:    "envelope calling an unknown tool",
+			text:    `{"tool_calls": [{"name": "order_pizza", "arguments": {}}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid envelope",
+			text:    `{"tool_calls": [{"name": "say_hello", "arguments": {"firstName": "Bob"}]}`,
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			toolCalls, err := ParseToolCalls(test.text, tools)
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %d tool calls", len(toolCalls))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(toolCalls) != len(test.calls) {
+				t.Fatalf("expected %d tool calls, got %d", len(test.calls), len(toolCalls))
+			}
+			for idx, toolCall := range toolCalls {
+				if toolCall.Function.Name != test.calls[idx] {
+					t.Errorf("tool call #%d: expected %s, got %s", idx, test.calls[idx], toolCall.Function.Name)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/openai/openai-go"
+)
+
+// Tool is a function the model can call.
+// Its JSON Schema is derived from the struct of its arguments (see SchemaOf).
+type Tool struct {
+	Name        string
+	Description string
+	Parameters  map[string]any // the JSON Schema of the arguments
+	SideEffects bool           // the tool changes something (e.g. places an order): its calls need an approval
+	Sequential  bool           // the calls of the tool in a turn run one at a time, in their order (see ExecuteToolCalls)
+
+	call func(ctx context.Context, arguments string) (string, error)
+}
+
+// Registry keeps the tools by name, in the order of registration.
+type Registry struct {
+	tools []*Tool
+
+	// MaxValidationRetries is the number of times the model can fix the invalid arguments of a tool
+	// in a conversation (see WithConversation):
+	// the validation errors are sent back as the result of the tool call,
+	// then, when the limit is reached, the tool is disabled for the rest of the conversation
+	// (its calls are refused without being validated).
+	MaxValidationRetries int
+	// Logger logs each tool call rejected by the validation (nil: no log).
+	Logger *slog.Logger
+	// Approve approves the calls of the tools with side effects (nil: no approval, see Review).
+	Approve ApprovalFunc
+
+	mutex    sync.Mutex
+	failures map[failureKey]int // number of rejected calls by conversation and tool
+}
+
+// failureKey identifies the rejected calls of a tool in a conversation (see ConversationID):
+// each conversation has its own validation retries.
+type failureKey struct {
+	Conversation string
+	Tool         string
+}
+
+// NewRegistry creates an empty tool registry.
+func NewRegistry() *Registry {
+	return &Registry{MaxValidationRetries: 2, failures: map[failureKey]int{}}
+}
+
+// Register adds a tool to the registry. The tool is a Go function taking a typed args struct:
+//
+//	type SayHelloArgs struct {
+//		FirstName string `json:"firstName" description:"first name of the person" required:"true"`
+//	}
+//	Register(registry, "say_hello", "Say hello to the given person", sayHello)
+//
+// The arguments of a tool call are decoded and validated into the struct before calling the function.
+// The options change the tool, e.g. WithSideEffects or Sequential.
+func Register[T any](r *Registry, name, description string, fn func(ctx context.Context, args T) (string, error), options ...ToolOption) {
+	if r.Get(name) != nil {
+		panic("the tool " + name + " is already registered")
+	}
+	argsType := reflect.TypeFor[T]()
+	if argsType.Kind() != reflect.Struct {
+		panic("the arguments of the tool " + name + " must be a struct")
+	}
+	schema := SchemaOf(argsType)
+
+	tool := &Tool{
+		Name:        name,
+		Description: description,
+		Parameters:  schema,
+		call: func(ctx context.Context, arguments string) (string, error) {
+			var args T
+			if err := DecodeArguments(arguments, schema, &args); err != nil {
+				return "", err
+			}
+			return fn(ctx, args)
+		},
+	}
+	for _, option := range options {
+		option(tool)
+	}
+	r.tools = append(r.tools, tool)
+}
+
+// ToolOption is an option of the registration of a tool.
+type ToolOption func(tool *Tool)
+
+// WithSideEffects marks the tool as side-effecting: its calls need an approval (see Registry.Review).
+// The tools are read-only by default.
+func WithSideEffects() ToolOption {
+	return func(tool *Tool) {
+		tool.SideEffects = true
+	}
+}
+
+// Sequential marks the tool as sequential: in a model turn, its calls run one at a time in the order
+// of the calls, e.g. the tools changing or reading the cart (add then view must see the added pizza).
+// The other tools run concurrently.
+func Sequential() ToolOption {
+	return func(tool *Tool) {
+		tool.Sequential = true
+	}
+}
+
+// IsSequential reports whether the tool of the call is sequential (see Sequential).
+func (r *Registry) IsSequential(toolCall openai.ChatCompletionMessageToolCall) bool {
+	tool := r.Get(toolCall.Function.Name)
+	return tool != nil && tool.Sequential
+}
+
+// Get returns the tool with the given name (nil if there is none).
+func (r *Registry) Get(name string) *Tool {
+	for _, tool := range r.tools {
+		if tool.Name == name {
+			return tool
+		}
+	}
+	return nil
+}
+
+// Tools returns the definitions of the tools for the chat completion request.
+func (r *Registry) Tools() []openai.ChatCompletionToolParam {
+	tools := []openai.ChatCompletionToolParam{}
+	for _, tool := range r.tools {
+		tools = append(tools, openai.ChatCompletionToolParam{
+			Function: openai.FunctionDefinitionParam{
+				Name:        tool.Name,
+				Description: openai.String(tool.Description),
+				Parameters:  openai.FunctionParameters(tool.Parameters),
+			},
+		})
+	}
+	return tools
+}
+
+// Execute dispatches the tool call to the tool with the same name, and returns its result.
+// The errors (unknown tool, invalid arguments, failure of the tool) are returned as the result,
+// so the model can react to them.
+func (r *Registry) Execute(ctx context.Context, toolCall openai.ChatCompletionMessageToolCall) string {
+	tool := r.Get(toolCall.Function.Name)
+	if tool == nil {
+		return fmt.Sprintf("Error: unknown tool %s", toolCall.Function.Name)
+	}
+	key := failureKey{ConversationID(ctx), tool.Name}
+	r.mutex.Lock()
+	disabled := r.failures[key] > r.MaxValidationRetries
+	r.mutex.Unlock()
+	if disabled {
+		return fmt.Sprintf("Error: %s is disabled for this conversation (its arguments were invalid too many times): answer without it.", tool.Name)
+	}
+	result, err := tool.call(ctx, toolCall.Function.Arguments)
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		return r.rejectArguments(ctx, tool, toolCall, validationErr)
+	}
+	// the arguments are valid: the model gets new retries for its next mistakes
+	r.mutex.Lock()
+	delete(r.failures, key)
+	r.mutex.Unlock()
+	if err != nil {
+		return fmt.Sprintf("Error: %s: %v", tool.Name, err)
+	}
+	return result
+}
+
+// rejectArguments logs the rejected tool call, and returns the validation errors
+// with the instructions to fix the arguments (or to stop calling the tool when the retries are exhausted).
+func (r *Registry) rejectArguments(ctx context.Context, tool *Tool, toolCall openai.ChatCompletionMessageToolCall, err *ValidationError) string {
+	key := failureKey{ConversationID(ctx), tool.Name}
+	r.mutex.Lock()
+	if r.failures == nil {
+		r.failures = map[failureKey]int{}
+	}
+	r.failures[key]++
+	attempt := r.failures[key]
+	r.mutex.Unlock()
+
+	if r.Logger != nil {
+		r.Logger.Warn("tool call rejected",
+			slog.String("conversation", key.Conversation),
+			slog.String("tool", tool.Name),
+			slog.String("tool_call_id", toolCall.ID),
+			slog.Int("attempt", attempt),
+			slog.Int("max_retries", r.MaxValidationRetries),
+			slog.String("arguments", toolCall.Function.Arguments),
+			slog.Any("errors", err.Errors),
+		)
+	}
+
+	if attempt > r.MaxValidationRetries {
+		return fmt.Sprintf("Error: %s: %v\nThe arguments are still invalid after %d attempts: don't call %s again, answer without it.",
+			tool.Name, err, attempt, tool.Name)
+	}
+	return fmt.Sprintf("Error: %s: %v\nFix the arguments to match the parameters of the tool and call %s again.",
+		tool.Name, err, tool.Name)
+}
+
+// SchemaOf returns the JSON Schema of a Go type.
+// The fields of a struct use the tags:
+//   - json: the name of the property (fields with "-" are skipped),
+//   - description: the description of the property,
+//   - enum: the comma-separated allowed values,
+//   - format: the format of a string (date, date-time, time, email, uri),
+//   - minimum, maximum: the bounds of a number,
+//   - required:"true": the property is required.
+//
+// The optional pointer fields also accept null (e.g. "type": ["number", "null"]), decoded as nil.
+func SchemaOf(t reflect.Type) map[string]any {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return SchemaOf(t.Elem())
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": SchemaOf(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": SchemaOf(t.Elem())}
+	case reflect.Struct:
+		properties := map[string]any{}
+		required := []string{}
+		for idx := range t.NumField() {
+			field := t.Field(idx)
+			if !field.IsExported() || field.Tag.Get("json") == "-" {
+				continue
+			}
+			property := SchemaOf(field.Type)
+			if description := field.Tag.Get("description"); description != "" {
+				property["description"] = description
+			}
+			if enum := field.Tag.Get("enum"); enum != "" {
+				property["enum"] = strings.Split(enum, ",")
+			}
+			if format := field.Tag.Get("format"); format != "" {
+				property["format"] = format
+			}
+			for _, bound := range []string{"minimum", "maximum"} {
+				if value, err := strconv.ParseFloat(field.Tag.Get(bound), 64); err == nil {
+					property[bound] = value
+				}
+			}
+			name := jsonName(field)
+			properties[name] = property
+			if field.Tag.Get("required") == "true" {
+				required = append(required, name)
+			} else if kind, ok := property["type"].(string); ok && field.Type.Kind() == reflect.Pointer {
+				property["type"] = []string{kind, "null"}
+			}
+		}
+		schema := map[string]any{"type": "object", "properties": properties}
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+		return schema
+	default:
+		return map[string]any{}
+	}
+}
+
+// jsonName returns the name of the property of a struct field.
+func jsonName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
+
+// DecodeArguments validates the JSON arguments of a tool call against the schema (see ValidateArguments),
+// then decodes them into the args struct.
+// The errors of the validation are returned all at once as a *ValidationError.
+func DecodeArguments(arguments string, schema map[string]any, args any) error {
+	if err := ValidateArguments(arguments, schema); err != nil {
+		return err
+	}
+	if strings.TrimSpace(arguments) == "" {
+		arguments = "{}"
+	}
+	decoder := json.NewDecoder(bytes.NewReader([]byte(arguments)))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(args); err != nil {
+		return &ValidationError{Errors: []string{err.Error()}}
+	}
+	return nil
+}
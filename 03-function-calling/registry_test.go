@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/openai/openai-go"
+)
+
+type testItem struct {
+	Name     string `json:"name" required:"true"`
+	Size     string `json:"size,omitempty" enum:"small,medium,large"`
+	Quantity int    `json:"quantity" minimum:"1" maximum:"10"`
+}
+
+type testOrderArgs struct {
+	Customer string            `json:"customer" description:"name of the customer" required:"true"`
+	Email    string            `json:"email,omitempty" format:"email"`
+	Pickup   string            `json:"pickup" format:"time"`
+	Date     string            `json:"date" format:"date"`
+	Website  string            `json:"website" format:"uri"`
+	Items    []testItem        `json:"items" required:"true"`
+	Notes    map[string]string `json:"notes"`
+	Express  bool              `json:"express"`
+	Tip      *float64          `json:"tip" minimum:"0"`
+	Level    int               // no json tag: the name of the field
+	Internal string            `json:"-"`
+	hidden   string
+}
+
+func TestSchemaOf(t *testing.T) {
+	tests := []struct {
+		name string
+		t    reflect.Type
+		want string
+	}{
+		{name: "string", t: reflect.TypeFor[string](), want: `{"type": "string"}`},
+		{name: "integer", t: reflect.TypeFor[uint8](), want: `{"type": "integer"}`},
+		{name: "number", t: reflect.TypeFor[float32](), want: `{"type": "number"}`},
+		{name: "slice", t: reflect.TypeFor[[]string](), want: `{"type": "array", "items": {"type": "string"}}`},
+		{name: "map", t: reflect.TypeFor[map[string]int](), want: `{"type": "object", "additionalProperties": {"type": "integer"}}`},
+		{
+			name: "struct with required, omitempty, enum and bounds",
+			t:    reflect.TypeFor[testItem](),
+			want: `{
+				"type": "object",
+				"properties": {
+					"name": {"type": "string"},
+					"size": {"type": "string", "enum": ["small", "medium", "large"]},
+					"quantity": {"type": "integer", "minimum": 1, "maximum": 10}
+				},
+				"required": ["name"]
+			}`,
+		},
+		{
+			name: "nested structs, slices, maps, formats and skipped fields",
+			t:    reflect.TypeFor[testOrderArgs](),
+			want: `{
+				"type": "object",
+				"properties": {
+					"customer": {"type": "string", "description": "name of the customer"},
+					"email": {"type": "string", "format": "email"},
+					"pickup": {"type": "string", "format": "time"},
+					"date": {"type": "string", "format": "date"},
+					"website": {"type": "string", "format": "uri"},
+					"items": {"type": "array", "items": {
+						"type": "object",
+						"properties": {
+							"name": {"type": "string"},
+							"size": {"type": "string", "enum": ["small", "medium", "large"]},
+							"quantity": {"type": "integer", "minimum": 1, "maximum": 10}
+						},
+						"required": ["name"]
+					}},
+					"notes": {"type": "object", "additionalProperties": {"type": "string"}},
+					"express": {"type": "boolean"},
+					"tip": {"type": ["number", "null"], "minimum": 0},
+					"Level": {"type": "integer"}
+				},
+				"required": ["customer", "items"]
+			}`,
+		},
+		{
+			name: "optional and required pointers",
+			t: reflect.TypeFor[struct {
+				Radius *float64 `json:"radius"`
+				Limit  *int     `json:"limit" required:"true"`
+			}](),
+			want: `{
+				"type": "object",
+				"properties": {
+					"radius": {"type": ["number", "null"]},
+					"limit": {"type": "integer"}
+				},
+				"required": ["limit"]
+			}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// compare the JSON documents (the schema is sent as JSON to the model)
+			data, err := json.Marshal(SchemaOf(test.t))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got, want any
+			if err := json.Unmarshal(data, &got); err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal([]byte(test.want), &want); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("unexpected schema:\n%s", data)
+			}
+		})
+	}
+}
+
+func TestValidateArguments(t *testing.T) {
+	schema := SchemaOf(reflect.TypeFor[testOrderArgs]())
+
+	tests := []struct {
+		name      string
+		arguments string
+		want      []string // the errors, nil when the arguments are valid
+	}{
+		{
+			name: "valid",
+			arguments: `{"customer": "Bob", "email": "bob@example.com", "pickup": "19:30", "date": "2025-06-01",
+				"website": "https://example.com", "items": [{"name": "Margherita", "size": "large", "quantity": 2}],
+				"notes": {"door": "blue"}, "express": true, "tip": 2.5, "Level": 3}`,
+		},
+		{
+			name:      "explicit null for an optional pointer",
+			arguments: `{"customer": "Bob", "items": [], "tip": null}`,
+		},
+		{
+			name:      "null for the other properties",
+			arguments: `{"customer": null, "items": [], "express": null}`,
+			want:      []string{"arguments.customer must be a string, got null", "arguments.express must be a boolean, got null"},
+		},
+		{
+			name:      "missing required properties",
+			arguments: ``,
+			want:      []string{"arguments.customer is required", "arguments.items is required"},
+		},
+		{
+			name:      "invalid JSON",
+			arguments: `{"customer": "Bob"`,
+			want:      []string{"the arguments are not valid JSON: unexpected end of JSON input"},
+		},
+		{
+			name:      "not an object",
+			arguments: `["Bob"]`,
+			want:      []string{"arguments must be an object, got array"},
+		},
+		{
+			name:      "unknown properties",
+			arguments: `{"customer": "Bob", "items": [], "color": "red", "Internal": "x", "hidden": "y"}`,
+			want: []string{
+				"arguments.Internal is not a known property",
+				"arguments.color is not a known property",
+				"arguments.hidden is not a known property",
+			},
+		},
+		{
+			name:      "wrong types",
+			arguments: `{"customer": 42, "items": {}, "express": "yes", "tip": "5", "Level": "high"}`,
+			want: []string{
+				"arguments.Level must be an integer, got string",
+				"arguments.customer must be a string, got number",
+				"arguments.express must be a boolean, got string",
+				"arguments.items must be an array, got object",
+				"arguments.tip must be a number, got string",
+			},
+		},
+		{
+			name:      "nested items: required, enum, bounds and integer",
+			arguments: `{"customer": "Bob", "items": [{"size": "huge", "quantity": 0}, {"name": "Diavola", "quantity": 2.5}, {"name": "Regina", "quantity": 11}], "tip": -1}`,
+			want: []string{
+				"arguments.items[0].name is required",
+				"arguments.items[0].quantity must be greater than or equal to 1, got 0",
+				`arguments.items[0].size must be one of small, medium, large, got "huge"`,
+				"arguments.items[1].quantity must be an integer, got 2.5",
+				"arguments.items[2].quantity must be less than or equal to 10, got 11",
+				"arguments.tip must be greater than or equal to 0, got -1",
+			},
+		},
+		{
+			name:      "formats",
+			arguments: `{"customer": "Bob", "items": [], "email": "bob", "pickup": "25:00", "date": "2025-13-01", "website": "example.com"}`,
+			want: []string{
+				`arguments.date must be a valid date: parsing time "2025-13-01": month out of range`,
+				"arguments.email must be a valid email: mail: missing '@' or angle-addr",
+				`arguments.pickup must be a valid time: expected HH:MM or HH:MM:SS, got "25:00"`,
+				`arguments.website must be a valid uri: missing scheme in "example.com"`,
+			},
+		},
+		{
+			name:      "values of a map",
+			arguments: `{"customer": "Bob", "items": [], "notes": {"door": 1}}`,
+			want:      []string{"arguments.notes.door must be a string, got number"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := ValidateArguments(test.arguments, schema)
+			if test.want == nil {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			var validationErr *ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("expected a *ValidationError, got %v", err)
+			}
+			if !slices.Equal(validationErr.Errors, test.want) {
+				t.Errorf("unexpected errors:\n%q\nexpected:\n%q", validationErr.Errors, test.want)
+			}
+		})
+	}
+}
+
+func TestDecodeArguments(t *testing.T) {
+	schema := SchemaOf(reflect.TypeFor[testOrderArgs]())
+
+	var args testOrderArgs
+	err := DecodeArguments(`{"customer": "Bob", "items": [{"name": "Margherita", "quantity": 2}], "tip": null, "Level": 1}`, schema, &args)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := testOrderArgs{Customer: "Bob", Items: []testItem{{Name: "Margherita", Quantity: 2}}, Level: 1}
+	if !reflect.DeepEqual(args, want) {
+		t.Errorf("expected %+v, got %+v", want, args)
+	}
+
+	// the invalid arguments are not decoded
+	args = testOrderArgs{}
+	err = DecodeArguments(`{"customer": "Bob"}`, schema, &args)
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) || args.Customer != "" {
+		t.Errorf("expected a *ValidationError without decoding, got %v and %+v", err, args)
+	}
+}
+
+func TestExecuteValidationRetries(t *testing.T) {
+	registry := NewRegistry()
+	registry.MaxValidationRetries = 1
+	calls := 0
+	Register(registry, "order", "Order pizzas", func(ctx context.Context, args testItem) (string, error) {
+		calls++
+		return "ordered " + args.Name, nil
+	})
+	toolCall := func(arguments string) openai.ChatCompletionMessageToolCall {
+		return openai.ChatCompletionMessageToolCall{ID: "call", Function: openai.ChatCompletionMessageToolCallFunction{Name: "order", Arguments: arguments}}
+	}
+	alice := WithConversation(context.Background(), "alice")
+	bob := WithConversation(context.Background(), "bob")
+
+	steps := []struct {
+		ctx       context.Context
+		arguments string
+		want      string // the beginning of the result
+	}{
+		{ctx: alice, arguments: `{}`, want: "Error: order: invalid arguments"},
+		{ctx: alice, arguments: `{"name": "Regina"}`, want: "ordered Regina"}, // the valid call resets the retries
+		{ctx: alice, arguments: `{}`, want: "Error: order: invalid arguments"},
+		{ctx: alice, arguments: `{"quantity": 0}`, want: "Error: order: invalid arguments"},
+		// the retries are exhausted: the tool is not called anymore, even with valid arguments
+		{ctx: alice, arguments: `{"name": "Margherita"}`, want: "Error: order is disabled for this conversation"},
+		{ctx: alice, arguments: `{}`, want: "Error: order is disabled for this conversation"},
+		// the other conversations have their own retries
+		{ctx: bob, arguments: `{"name": "Diavola"}`, want: "ordered Diavola"},
+	}
+
+	for idx, step := range steps {
+		if result := registry.Execute(step.ctx, toolCall(step.arguments)); !strings.HasPrefix(result, step.want) {
+			t.Errorf("call #%d: expected %q..., got %q", idx, step.want, result)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("expected 2 calls of the tool, got %d", calls)
+	}
+}
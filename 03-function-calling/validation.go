@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ValidationError is returned when the arguments of a tool call don't match the schema of the tool.
+// Its message lists all the errors, so the model can fix all of them at once.
+type ValidationError struct {
+	Errors []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid arguments:\n- " + strings.Join(e.Errors, "\n- ")
+}
+
+// ValidateArguments checks the JSON arguments of a tool call against the JSON Schema of the tool.
+func ValidateArguments(arguments string, schema map[string]any) error {
+	if strings.TrimSpace(arguments) == "" {
+		arguments = "{}"
+	}
+	var value any
+	if err := json.Unmarshal([]byte(arguments), &value); err != nil {
+		return &ValidationError{Errors: []string{"the arguments are not valid JSON: " + err.Error()}}
+	}
+	if errors := ValidateValue(value, schema, "arguments"); len(errors) > 0 {
+		return &ValidationError{Errors: errors}
+	}
+	return nil
+}
+
+// ValidateValue checks a decoded JSON value against a schema (see SchemaOf):
+// the types, the required and unknown properties, the enums, the formats, and the minimum and maximum.
+// It returns the errors, prefixed by the path of the value (e.g. arguments.items[0].size).
+func ValidateValue(value any, schema map[string]any, path string) []string {
+	kind := schema["type"]
+	// a nullable type (e.g. ["number", "null"]): null, or a value of the other type
+	if types, ok := kind.([]string); ok {
+		if value == nil && slices.Contains(types, "null") {
+			return nil
+		}
+		kind = nil
+		if idx := slices.IndexFunc(types, func(name string) bool { return name != "null" }); idx >= 0 {
+			kind = types[idx]
+		}
+	}
+	errors := []string{}
+	switch kind {
+	case "object":
+		object, ok := value.(map[string]any)
+		if !ok {
+			return []string{fmt.Sprintf("%s must be an object, got %s", path, jsonType(value))}
+		}
+		properties, _ := schema["properties"].(map[string]any)
+		if required, ok := schema["required"].([]string); ok {
+			for _, name := range required {
+				if _, ok := object[name]; !ok {
+					errors = append(errors, fmt.Sprintf("%s.%s is required", path, name))
+				}
+			}
+		}
+		additional, _ := schema["additionalProperties"].(map[string]any)
+		names := make([]string, 0, len(object))
+		for name := range object {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if property, ok := properties[name].(map[string]any); ok {
+				errors = append(errors, ValidateValue(object[name], property, path+"."+name)...)
+			} else if additional != nil {
+				errors = append(errors, ValidateValue(object[name], additional, path+"."+name)...)
+			} else {
+				errors = append(errors, fmt.Sprintf("%s.%s is not a known property", path, name))
+			}
+		}
+	case "array":
+		array, ok := value.([]any)
+		if !ok {
+			return []string{fmt.Sprintf("%s must be an array, got %s", path, jsonType(value))}
+		}
+		if items, ok := schema["items"].(map[string]any); ok {
+			for idx, item := range array {
+				errors = append(errors, ValidateValue(item, items, fmt.Sprintf("%s[%d]", path, idx))...)
+			}
+		}
+	case "string":
+		text, ok := value.(string)
+		if !ok {
+			return []string{fmt.Sprintf("%s must be a string, got %s", path, jsonType(value))}
+		}
+		if enum, ok := schema["enum"].([]string); ok && !slices.Contains(enum, text) {
+			errors = append(errors, fmt.Sprintf("%s must be one of %s, got %q", path, strings.Join(enum, ", "), text))
+		}
+		if format, ok := schema["format"].(string); ok {
+			if err := checkFormat(format, text); err != nil {
+				errors = append(errors, fmt.Sprintf("%s must be a valid %s: %v", path, format, err))
+			}
+		}
+	case "integer", "number":
+		number, ok := value.(float64)
+		if !ok {
+			expected := "a number"
+			if kind == "integer" {
+				expected = "an integer"
+			}
+			return []string{fmt.Sprintf("%s must be %s, got %s", path, expected, jsonType(value))}
+		}
+		if kind == "integer" && number != math.Trunc(number) {
+			errors = append(errors, fmt.Sprintf("%s must be an integer, got %v", path, number))
+		}
+		if minimum, ok := schema["minimum"].(float64); ok && number < minimum {
+			errors = append(errors, fmt.Sprintf("%s must be greater than or equal to %v, got %v", path, minimum, number))
+		}
+		if maximum, ok := schema["maximum"].(float64); ok && number > maximum {
+			errors = append(errors, fmt.Sprintf("%s must be less than or equal to %v, got %v", path, maximum, number))
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return []string{fmt.Sprintf("%s must be a boolean, got %s", path, jsonType(value))}
+		}
+	}
+	return errors
+}
+
+var timeFormat = regexp.MustCompile(`^([01]\d|2[0-3]):[0-5]\d(:[0-5]\d)?$`)
+
+// checkFormat checks the usual string formats of JSON Schema.
+func checkFormat(format, text string) error {
+	var err error
+	switch format {
+	case "date":
+		_, err = time.Parse(time.DateOnly, text)
+	case "date-time":
+		_, err = time.Parse(time.RFC3339, text)
+	case "time":
+		if !timeFormat.MatchString(text) {
+			err = fmt.Errorf("expected HH:MM or HH:MM:SS, got %q", text)
+		}
+	case "email":
+		_, err = mail.ParseAddress(text)
+	case "uri":
+		var parsed *url.URL
+		parsed, err = url.Parse(text)
+		if err == nil && parsed.Scheme == "" {
+			err = fmt.Errorf("missing scheme in %q", text)
+		}
+	}
+	return err
+}
+
+// jsonType returns the JSON type of a decoded value.
+func jsonType(value any) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
@@ -9,6 +9,8 @@ import (
 	"log"
 	"os"
 	"os/exec"
+	"strconv"
+	"time"
 
 	mcp_golang "github.com/metoro-io/mcp-golang"
 	"github.com/metoro-io/mcp-golang/transport/stdio"
@@ -132,14 +134,27 @@ func main() {
 		- and imagine a quick presentation sentence for each pizzeria.
 	*/
 
-	//! Make completion request
-	completion, err := client.Chat.Completions.New(ctx, params)
-	if err != nil {
-		panic(err)
+	//! Make a streaming completion request:
+	//! the accumulator assembles the tool calls from their deltas (by index), and the content (if any) is displayed as it arrives
+	toolsStream := client.Chat.Completions.NewStreaming(ctx, params)
+	accumulator := openai.ChatCompletionAccumulator{}
+	for toolsStream.Next() {
+		chunk := toolsStream.Current()
+		accumulator.AddChunk(chunk)
+		if len(chunk.Choices) > 0 {
+			fmt.Print(chunk.Choices[0].Delta.Content)
+		}
+	}
+	if err := toolsStream.Err(); err != nil {
+		log.Fatalln("😡:", err)
+	}
+	toolsStream.Close()
+	if len(accumulator.Choices) == 0 {
+		log.Fatalln("😡: no choice in the completion")
 	}
 
 	//! List of the detected tool calls by the LLM
-	detectedToolCalls := completion.Choices[0].Message.ToolCalls
+	detectedToolCalls := accumulator.Choices[0].Message.ToolCalls
 
 	// Return early if there are no tool calls
 	if len(detectedToolCalls) == 0 {
@@ -156,28 +171,52 @@ func main() {
 
 	//os.Exit(0)
 
-	//! call the tools to create a list of pizzerias addresses
-	addressesKnowledgeBase := "PIZZERIAS ADRESSES:\n"
-
-	for _, toolCall := range detectedToolCalls {
+	//! call the tools (concurrently) to create a list of pizzerias addresses
+	callTool := func(ctx context.Context, toolCall openai.ChatCompletionMessageToolCall) (string, error) {
 		fmt.Println("📣 calling ", toolCall.Function.Name, toolCall.Function.Arguments)
 
 		var args map[string]any
-		err = json.Unmarshal([]byte(toolCall.Function.Arguments), &args)
-		if err != nil {
-			log.Println("😡 Failed to unmarshal arguments:", err)
+		if err := json.Unmarshal([]byte(toolCall.Function.Arguments), &args); err != nil {
+			return "", fmt.Errorf("failed to unmarshal arguments: %w", err)
 		}
 		fmt.Println("📝 Arguments:", args)
 
 		// Call the tool with the arguments
 		toolResponse, err := mcpClient.CallTool(ctx, toolCall.Function.Name, args)
 		if err != nil {
-			log.Println("😡 Failed to call tool:", err)
+			return "", fmt.Errorf("failed to call tool: %w", err)
+		}
+		if toolResponse == nil || len(toolResponse.Content) == 0 || toolResponse.Content[0].TextContent == nil {
+			return "", nil
 		}
-		if toolResponse != nil && len(toolResponse.Content) > 0 && toolResponse.Content[0].TextContent != nil {
-			fmt.Println("🎉📝 Tool response:", toolResponse.Content[0].TextContent.Text)
-			addressesKnowledgeBase += toolResponse.Content[0].TextContent.Text
+		return toolResponse.Content[0].TextContent.Text, nil
+	}
+
+	//! the calls of the side-effecting tools need an approval
+	//! (the tools are side-effecting unless they are listed in MCP_READ_ONLY_TOOLS)
+	approve, err := NewApproval(GetEnv("TOOL_APPROVAL", ApprovalPrompt), os.Stdin, os.Stdout)
+	if err != nil {
+		log.Fatalln("😡", err)
+	}
+
+	results := ExecuteApprovedToolCalls(ctx, detectedToolCalls,
+		ReadOnlyTools(os.Getenv("MCP_READ_ONLY_TOOLS")),
+		approve,
+		GetEnvInt("TOOL_CONCURRENCY", 4),
+		time.Duration(GetEnvInt("TOOL_TIMEOUT_SECONDS", 30))*time.Second,
+		callTool,
+	)
+
+	//! the results are in the order of the tool calls
+	addressesKnowledgeBase := "PIZZERIAS ADRESSES:\n"
+
+	for _, result := range results {
+		if result.Err != nil {
+			log.Println("😡", result.ToolCall.Function.Name, result.Err)
+			continue
 		}
+		fmt.Println("🎉📝 Tool response:", result.Content)
+		addressesKnowledgeBase += result.Content
 	}
 
 	//os.Exit(0)
@@ -238,10 +277,35 @@ func setupCommand(cmd *exec.Cmd) (stdin io.WriteCloser, stdout io.ReadCloser, er
 	return stdin, stdout, nil
 }
 
+// GetEnv returns the value of an environment variable,
+// or the default value if the variable is not set.
+func GetEnv(name, defaultValue string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+// GetEnvInt returns the value of an integer environment variable,
+// or the default value if the variable is not set or invalid.
+func GetEnvInt(name string, defaultValue int) int {
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
 func JSONPretty(toolCall openai.ChatCompletionMessageToolCall) string {
+	raw := toolCall.RawJSON()
+	if raw == "" {
+		// the tool call was not unmarshaled from a response (e.g. accumulated from a stream)
+		data, _ := json.Marshal(toolCall)
+		raw = string(data)
+	}
 	// how to pretty print a json string
 	var prettyJSON bytes.Buffer
-	_ = json.Indent(&prettyJSON, []byte(toolCall.RawJSON()), "", "\t")
+	_ = json.Indent(&prettyJSON, []byte(raw), "", "\t")
 	// and remove escape characters
 	prettyJSONString := prettyJSON.String()
 	prettyJSONString = string(bytes.ReplaceAll([]byte(prettyJSONString), []byte("\\\""), []byte("\"")))
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/openai/openai-go"
+)
+
+// ToolExecutor executes a tool call and returns the text of its result.
+type ToolExecutor func(ctx context.Context, toolCall openai.ChatCompletionMessageToolCall) (string, error)
+
+// ToolResult is the result of a tool call.
+type ToolResult struct {
+	ToolCall openai.ChatCompletionMessageToolCall
+	Content  string
+	Err      error
+}
+
+// ExecuteToolCalls executes the tool calls of a model turn concurrently:
+//   - at most `concurrency` tool calls run at the same time (1 or less: one by one),
+//   - each tool call gets its own context with the timeout (0: no timeout),
+//     a tool call still running after the timeout gets an error result
+//     (the tool keeps its place in the concurrency limit until it returns),
+//   - a panic of a tool is converted to an error result.
+//
+// It returns the results in the order of the tool calls.
+func ExecuteToolCalls(ctx context.Context, toolCalls []openai.ChatCompletionMessageToolCall, concurrency int, timeout time.Duration, execute ToolExecutor) []ToolResult {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	results := make([]ToolResult, len(toolCalls))
+	semaphore := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+
+	for idx, toolCall := range toolCalls {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			// the place is released when the tool returns, which can be after the timeout
+			results[idx] = executeToolCall(ctx, toolCall, timeout, execute, func() { <-semaphore })
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// executeToolCall executes a tool call with a timeout, and recovers from the panics of the tool.
+// `finished` is called when the tool returns, which can be after the timeout.
+func executeToolCall(ctx context.Context, toolCall openai.ChatCompletionMessageToolCall, timeout time.Duration, execute ToolExecutor, finished func()) ToolResult {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	// the tool runs in its own goroutine, so a tool ignoring the context doesn't block the turn
+	// (the buffered channel lets it finish after the timeout)
+	done := make(chan ToolResult, 1)
+	go func() {
+		defer finished()
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				done <- ToolResult{ToolCall: toolCall, Err: fmt.Errorf("the tool %s panicked: %v", toolCall.Function.Name, recovered)}
+			}
+		}()
+		content, err := execute(ctx, toolCall)
+		done <- ToolResult{ToolCall: toolCall, Content: content, Err: err}
+	}()
+
+	select {
+	case result := <-done:
+		return result
+	case <-ctx.Done():
+		return ToolResult{ToolCall: toolCall, Err: fmt.Errorf("the tool %s: %w", toolCall.Function.Name, ctx.Err())}
+	}
+}